@@ -0,0 +1,350 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrUnsupportedArchive is returned by ExtractArchive for content that is neither a zip nor a gzipped tar archive.
+var ErrUnsupportedArchive = errors.New("the archive format is not supported")
+
+// ErrArchiveTooLarge is returned when the uncompressed content of an archive is larger than MaxSize.
+var ErrArchiveTooLarge = errors.New("the archive is too large when uncompressed")
+
+// ErrTooManyEntries is returned when an archive holds more entries than MaxEntries.
+var ErrTooManyEntries = errors.New("the archive has too many entries")
+
+// ErrCompressionRatio is returned when an archive expands by more than MaxRatio, as zip bombs do.
+var ErrCompressionRatio = errors.New("the archive is compressed suspiciously well")
+
+// ErrUnsafeEntry is returned for entries whose path leaves the destination, is too deep or is repeated, and for
+// links and special files.
+var ErrUnsafeEntry = errors.New("the archive entry is not safe to extract")
+
+// ratioThreshold is the number of uncompressed bytes below which MaxRatio is not applied, since small files
+// of repetitive content compress very well without being bombs.
+const ratioThreshold = 1 << 20
+
+// ArchiveLimits limits what ExtractArchive accepts. Zero values select the defaults.
+type ArchiveLimits struct {
+	// MaxSize is the total uncompressed size of the archive. The default is 1 gb.
+	MaxSize int64
+	// MaxEntries is the number of entries, including directories. The default is 1000.
+	MaxEntries int
+	// MaxRatio is the largest ratio of uncompressed to compressed size, applied to each zip entry and to a
+	// gzipped tar archive as a whole once more than 1 mb has been uncompressed. The default is 100.
+	MaxRatio float64
+	// MaxDepth is the number of directories and the file name in the path of an entry. The default is 10.
+	MaxDepth int
+	// AllowedTypes, if not empty, replaces AllowedTypes of Tools for the extracted entries.
+	AllowedTypes []string
+}
+
+// ArchiveError reports the entry of an archive that could not be extracted.
+type ArchiveError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("archive entry %s: %v", e.Entry, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractedFile is a file written by ExtractArchive.
+type ExtractedFile struct {
+	// Name is the path of the file in the archive, and below destDir.
+	Name        string
+	FileSize    int64
+	ContentType string
+}
+
+// ExtractUploadedFile extracts the archive f, uploaded to uploadDir, into destDir. See ExtractArchive.
+func (t *Tools) ExtractUploadedFile(uploadDir string, f *UploadedFile, destDir string) ([]ExtractedFile, error) {
+	rc, err := t.storage().Open(storageKey(uploadDir, f.NewFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return t.ExtractArchive(rc, destDir)
+}
+
+// ExtractArchive writes the files in the zip or gzipped tar archive read from r to destDir in the Storage,
+// keeping their paths. The archive is checked against ArchiveLimits, and each file against the allowed types,
+// as it is extracted. Links, special files and paths that would leave destDir are rejected. If any entry fails
+// the files already extracted are removed and an *ArchiveError is returned.
+func (t *Tools) ExtractArchive(r io.Reader, destDir string) ([]ExtractedFile, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	x := &extractor{t: t, destDir: destDir, seen: make(map[string]bool)}
+	x.limits = t.ArchiveLimits.withDefaults()
+	if len(x.limits.AllowedTypes) == 0 {
+		x.limits.AllowedTypes = t.AllowedTypes
+	}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		err = x.zip(r, br)
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		err = x.tarGz(br)
+	default:
+		return nil, ErrUnsupportedArchive
+	}
+	if err != nil {
+		for _, f := range x.files {
+			t.storage().Delete(storageKey(destDir, f.Name))
+		}
+		return nil, err
+	}
+
+	return x.files, nil
+}
+
+// withDefaults returns l with its zero values replaced by the defaults.
+func (l ArchiveLimits) withDefaults() ArchiveLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = 1024 * 1024 * 1024
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = 1000
+	}
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = 100
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = 10
+	}
+	return l
+}
+
+// extractor holds the state of one ExtractArchive call.
+type extractor struct {
+	t       *Tools
+	destDir string
+	limits  ArchiveLimits
+	entries int
+	size    int64
+	seen    map[string]bool
+	files   []ExtractedFile
+}
+
+// zip extracts a zip archive. Zip archives need random access, so unless r provides it the archive, read
+// from br, is first copied to a temporary file.
+func (x *extractor) zip(r io.Reader, br *bufio.Reader) error {
+	var ra io.ReaderAt
+	var size int64
+	switch v := r.(type) {
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil {
+			return err
+		}
+		ra, size = v, fi.Size()
+	case interface {
+		io.ReaderAt
+		Size() int64
+	}:
+		ra, size = v, v.Size()
+	default:
+		tmp, err := os.CreateTemp("", ".archive-*")
+		if err != nil {
+			return err
+		}
+		defer discardSpool(tmp)
+		if size, err = io.Copy(tmp, br); err != nil {
+			return err
+		}
+		ra = tmp
+	}
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if err := x.zipEntry(zf); err != nil {
+			return &ArchiveError{Entry: zf.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipEntry(zf *zip.File) error {
+	name, err := x.entryName(zf.Name)
+	if err != nil {
+		return err
+	}
+
+	mode := zf.Mode()
+	if mode.IsDir() {
+		return nil
+	}
+	if !mode.IsRegular() {
+		return ErrUnsafeEntry
+	}
+
+	if zf.UncompressedSize64 > ratioThreshold && float64(zf.UncompressedSize64) > x.limits.MaxRatio*float64(zf.CompressedSize64) {
+		return ErrCompressionRatio
+	}
+	if zf.UncompressedSize64 > uint64(x.limits.MaxSize-x.size) {
+		return ErrArchiveTooLarge
+	}
+
+	// archive/zip fails reading more than UncompressedSize64, so the checks above hold for the content
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return x.write(name, rc)
+}
+
+// tarGz extracts a gzipped tar archive, checking the compression ratio of the archive as a whole.
+func (x *extractor) tarGz(r io.Reader) error {
+	compressed := &countingReader{r: r}
+	gz, err := gzip.NewReader(compressed)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(&ratioReader{r: gz, compressed: compressed, maxRatio: x.limits.MaxRatio})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.tarEntry(tr, hdr); err != nil {
+			return &ArchiveError{Entry: hdr.Name, Err: err}
+		}
+	}
+}
+
+func (x *extractor) tarEntry(tr *tar.Reader, hdr *tar.Header) error {
+	if hdr.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+
+	name, err := x.entryName(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return nil
+	case tar.TypeReg:
+	default:
+		return ErrUnsafeEntry
+	}
+
+	if hdr.Size > x.limits.MaxSize-x.size {
+		return ErrArchiveTooLarge
+	}
+	return x.write(name, tr)
+}
+
+// entryName counts an entry and returns its name cleaned, or an error if it is not safe.
+func (x *extractor) entryName(name string) (string, error) {
+	x.entries++
+	if x.entries > x.limits.MaxEntries {
+		return "", ErrTooManyEntries
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) || (len(name) > 1 && name[1] == ':') {
+		return "", ErrUnsafeEntry
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", ErrUnsafeEntry
+		}
+	}
+
+	name = path.Clean(name)
+	if name == "." || strings.Count(name, "/")+1 > x.limits.MaxDepth || x.seen[name] {
+		return "", ErrUnsafeEntry
+	}
+	x.seen[name] = true
+
+	return name, nil
+}
+
+// write checks the type of an entry and stores it under name below destDir.
+func (x *extractor) write(name string, r io.Reader) error {
+	t := x.t
+	head := make([]byte, t.headLen())
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+
+	fileType := t.detectContentType(head)
+	if !isAllowedType(x.limits.AllowedTypes, fileType) {
+		return ErrFileTypeNotAllowed
+	}
+	if err := t.checkExtension(name, fileType); err != nil {
+		return err
+	}
+
+	in := &maxBytesReader{r: io.MultiReader(bytes.NewReader(head), r), n: x.limits.MaxSize - x.size, err: ErrArchiveTooLarge}
+	size, err := t.storage().Put(storageKey(x.destDir, name), in)
+	if err != nil {
+		return err
+	}
+	x.size += size
+	x.files = append(x.files, ExtractedFile{Name: name, FileSize: size, ContentType: fileType})
+
+	return nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader reads uncompressed data from r and fails with ErrCompressionRatio once more than ratioThreshold
+// bytes have been read and they are more than maxRatio times the bytes read from compressed.
+type ratioReader struct {
+	r          io.Reader
+	compressed *countingReader
+	maxRatio   float64
+	n          int64
+}
+
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.n += int64(n)
+	if rr.n > ratioThreshold && float64(rr.n) > rr.maxRatio*float64(rr.compressed.n) {
+		return n, ErrCompressionRatio
+	}
+	return n, err
+}
@@ -0,0 +1,155 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEntry is an entry of an archive built by testArchive. A non empty link makes it a symbolic link.
+type testEntry struct {
+	name string
+	data []byte
+	link string
+}
+
+// testArchive returns a zip archive, or a gzipped tar archive if tarGz is true, holding entries.
+func testArchive(t *testing.T, tarGz bool, entries ...testEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if !tarGz {
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			data := e.data
+			if e.link != "" {
+				hdr.SetMode(os.ModeSymlink | 0777)
+				data = []byte(e.link)
+			}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if strings.HasSuffix(e.name, "/") {
+			hdr.Typeflag, hdr.Name = tar.TypeDir, strings.TrimSuffix(e.name, "/")
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil && e.link == "" {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestToolsExtractArchive(t *testing.T) {
+	text := []byte("hello, world\n")
+	zeros := make([]byte, 8<<20)
+
+	extractTests := []struct {
+		name        string
+		entries     []testEntry
+		limits      ArchiveLimits
+		errExpected error
+	}{
+		{name: "valid", entries: []testEntry{{name: "a.txt", data: text}, {name: "docs/b.txt", data: text}, {name: "docs/", data: nil}}},
+		{name: "zip slip", entries: []testEntry{{name: "a.txt", data: text}, {name: "../evil.txt", data: text}}, errExpected: ErrUnsafeEntry},
+		{name: "absolute path", entries: []testEntry{{name: "/etc/evil.txt", data: text}}, errExpected: ErrUnsafeEntry},
+		{name: "windows path", entries: []testEntry{{name: `docs\..\..\evil.txt`, data: text}}, errExpected: ErrUnsafeEntry},
+		{name: "symlink", entries: []testEntry{{name: "link", link: "/etc/passwd"}}, errExpected: ErrUnsafeEntry},
+		{name: "duplicate", entries: []testEntry{{name: "a.txt", data: text}, {name: "./a.txt", data: text}}, errExpected: ErrUnsafeEntry},
+		{name: "too deep", entries: []testEntry{{name: "a/b/c/d.txt", data: text}}, limits: ArchiveLimits{MaxDepth: 3}, errExpected: ErrUnsafeEntry},
+		{name: "too many entries", entries: []testEntry{{name: "a.txt", data: text}, {name: "b.txt", data: text}}, limits: ArchiveLimits{MaxEntries: 1}, errExpected: ErrTooManyEntries},
+		{name: "too large", entries: []testEntry{{name: "a.txt", data: text}, {name: "b.txt", data: text}}, limits: ArchiveLimits{MaxSize: 20}, errExpected: ErrArchiveTooLarge},
+		{name: "bomb", entries: []testEntry{{name: "zeros.txt", data: zeros}}, errExpected: ErrCompressionRatio},
+		{name: "type not allowed", entries: []testEntry{{name: "a.txt", data: text}, {name: "b.png", data: testPNG(t, 2, 2)}}, errExpected: ErrFileTypeNotAllowed},
+	}
+
+	for _, tarGz := range []bool{false, true} {
+		for _, e := range extractTests {
+			destDir := t.TempDir()
+			testTools := Tools{AllowedTypes: []string{"text/plain; charset=utf-8", "application/octet-stream"}, ArchiveLimits: e.limits}
+
+			files, err := testTools.ExtractArchive(bytes.NewReader(testArchive(t, tarGz, e.entries...)), destDir)
+			if e.errExpected != nil {
+				var archiveErr *ArchiveError
+				if !errors.Is(err, e.errExpected) || !errors.As(err, &archiveErr) {
+					t.Errorf("tar %v, %s: expected an *ArchiveError for %v, received %v", tarGz, e.name, e.errExpected, err)
+				}
+				if entries, _ := os.ReadDir(destDir); len(entries) != 0 {
+					t.Errorf("tar %v, %s: expected nothing to be left in the destination", tarGz, e.name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("tar %v, %s: unexpected error %v", tarGz, e.name, err)
+				continue
+			}
+
+			if len(files) != 2 || files[1].Name != "docs/b.txt" || files[1].FileSize != int64(len(text)) {
+				t.Errorf("tar %v, %s: unexpected files %+v", tarGz, e.name, files)
+			}
+			if b, err := os.ReadFile(filepath.Join(destDir, "docs", "b.txt")); err != nil || !bytes.Equal(b, text) {
+				t.Errorf("tar %v, %s: expected docs/b.txt to be extracted (%v)", tarGz, e.name, err)
+			}
+		}
+	}
+
+	testTools := Tools{}
+	if _, err := testTools.ExtractArchive(strings.NewReader("not an archive"), t.TempDir()); err != ErrUnsupportedArchive {
+		t.Errorf("expected ErrUnsupportedArchive, received %v", err)
+	}
+}
+
+func TestToolsExtractUploadedFile(t *testing.T) {
+	uploadDir, destDir := t.TempDir(), t.TempDir()
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "bundle.zip", data: testArchive(t, false, testEntry{name: "readme.txt", data: []byte("read me")})})
+	f, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := testTools.ExtractUploadedFile(uploadDir, f, destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "readme.txt" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	if _, err := testTools.Storage.Stat(storageKey(destDir, "readme.txt")); err != nil {
+		t.Errorf("expected readme.txt to be stored: %v", err)
+	}
+}
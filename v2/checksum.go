@@ -0,0 +1,50 @@
+package toolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// checksums computes the checksums of an uploaded file as it is written to.
+type checksums struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32c hash.Hash32
+	w      io.Writer
+}
+
+// newChecksums returns a checksums that computes SHA-256 and, if enabled in Tools, MD5 and CRC-32C.
+func (t *Tools) newChecksums() *checksums {
+	c := &checksums{sha256: sha256.New()}
+	writers := []io.Writer{c.sha256}
+	if t.ChecksumMD5 {
+		c.md5 = md5.New()
+		writers = append(writers, c.md5)
+	}
+	if t.ChecksumCRC32C {
+		c.crc32c = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		writers = append(writers, c.crc32c)
+	}
+	c.w = io.MultiWriter(writers...)
+
+	return c
+}
+
+func (c *checksums) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// apply records the hex encoded checksums on f.
+func (c *checksums) apply(f *UploadedFile) {
+	f.SHA256 = hex.EncodeToString(c.sha256.Sum(nil))
+	if c.md5 != nil {
+		f.MD5 = hex.EncodeToString(c.md5.Sum(nil))
+	}
+	if c.crc32c != nil {
+		f.CRC32C = hex.EncodeToString(c.crc32c.Sum(nil))
+	}
+}
@@ -0,0 +1,84 @@
+package toolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func TestToolsUploadFileChecksums(t *testing.T) {
+	img := testPNG(t, 32, 32)
+	sha := sha256.Sum256(img)
+	md := md5.Sum(img)
+	crc := crc32.Checksum(img, crc32.MakeTable(crc32.Castagnoli))
+
+	for _, stream := range []bool{false, true} {
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "image.png", data: img})
+		testTools := Tools{StreamUploads: stream, ChecksumMD5: true, ChecksumCRC32C: true}
+		f, err := testTools.UploadOneFile(r, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.SHA256 != hex.EncodeToString(sha[:]) {
+			t.Errorf("stream %v: wrong SHA256 %s", stream, f.SHA256)
+		}
+		if f.MD5 != hex.EncodeToString(md[:]) {
+			t.Errorf("stream %v: wrong MD5 %s", stream, f.MD5)
+		}
+		if f.CRC32C != hex.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}) {
+			t.Errorf("stream %v: wrong CRC32C %s", stream, f.CRC32C)
+		}
+	}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "image.png", data: img})
+	var testTools Tools
+	f, err := testTools.UploadOneFile(r, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.SHA256 == "" || f.MD5 != "" || f.CRC32C != "" {
+		t.Errorf("expected only SHA256 by default, received %+v", f)
+	}
+}
+
+func TestToolsUploadFileContentAddressed(t *testing.T) {
+	img := testPNG(t, 32, 32)
+	sha := sha256.Sum256(img)
+	expectedName := hex.EncodeToString(sha[:]) + ".png"
+
+	for _, store := range []Storage{nil, &MemoryStorage{}} {
+		dir := t.TempDir()
+		testTools := Tools{ContentAddressed: true, Storage: store}
+
+		for i, name := range []string{"first.PNG", "second.png"} {
+			r := newMultipartRequest(t, testPart{field: "file", fileName: name, data: img})
+			f, err := testTools.UploadOneFile(r, dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.NewFileName != expectedName || f.OriginalFileName != name {
+				t.Errorf("expected %s to be stored as %s, received %s", name, expectedName, f.NewFileName)
+			}
+			if f.Duplicate != (i > 0) || f.FileSize != int64(len(img)) {
+				t.Errorf("upload %d: unexpected result %+v", i, f)
+			}
+		}
+
+		objects, err := testTools.storage().List(storageKey(dir, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 1 {
+			t.Errorf("expected a single stored file, found %d", len(objects))
+		}
+		if store == nil {
+			if _, err := os.Stat(storageKey(dir, expectedName)); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package toolkit
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// ExtensionMismatchError is returned when CheckExtension is set and the extension of an uploaded file is not
+// allowed or does not match its detected content type. It matches ErrFileTypeNotAllowed with errors.Is.
+type ExtensionMismatchError struct {
+	FileName  string
+	Extension string
+	// DetectedType is the content type detected from the content of the file.
+	DetectedType string
+	// ExtensionType is the content type registered for Extension, if any.
+	ExtensionType string
+}
+
+func (e *ExtensionMismatchError) Error() string {
+	if e.ExtensionType == "" {
+		return fmt.Sprintf("the extension %q of %s is not permitted for content of type %s", e.Extension, e.FileName, e.DetectedType)
+	}
+	return fmt.Sprintf("the extension %q of %s is for %s but the content is %s", e.Extension, e.FileName, e.ExtensionType, e.DetectedType)
+}
+
+// Is makes a mismatch count as a file type that is not permitted.
+func (e *ExtensionMismatchError) Is(target error) bool {
+	return target == ErrFileTypeNotAllowed
+}
+
+// checkExtension makes sure the extension of fileName is in AllowedExtensions, when that is set, and that
+// the content type registered for the extension agrees with fileType. It does nothing unless CheckExtension is set.
+func (t *Tools) checkExtension(fileName, fileType string) error {
+	if !t.CheckExtension {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	mismatch := &ExtensionMismatchError{FileName: fileName, Extension: ext, DetectedType: fileType}
+
+	if len(t.AllowedExtensions) > 0 {
+		allowed := false
+		for _, x := range t.AllowedExtensions {
+			if strings.EqualFold("."+strings.TrimPrefix(x, "."), ext) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return mismatch
+		}
+	}
+
+	mismatch.ExtensionType = t.typeByExtension(ext)
+	if mismatch.ExtensionType == "" || !sameContentType(mismatch.ExtensionType, fileType) {
+		return mismatch
+	}
+
+	return nil
+}
+
+// typeByExtension returns the content type registered for ext with Detectors, if it is set, or else with the
+// mime package.
+func (t *Tools) typeByExtension(ext string) string {
+	if t.Detectors != nil {
+		if ct := t.Detectors.TypeByExtension(ext); ct != "" {
+			return ct
+		}
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// sameContentType reports whether the content type registered for an extension agrees with the one detected
+// from the content. Parameters such as charset are ignored, and since sniffing can only tell that text is
+// text, plain text is accepted for any text type other than HTML, and for JSON.
+func sameContentType(extType, detected string) bool {
+	extType, detected = mediaType(extType), mediaType(detected)
+	if extType == detected {
+		return true
+	}
+
+	if detected == "text/plain" {
+		return (strings.HasPrefix(extType, "text/") && extType != "text/html") || extType == "application/json"
+	}
+	return false
+}
+
+// mediaType returns the lower cased type and subtype of a content type, without any parameters.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mt))
+}
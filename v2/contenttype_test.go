@@ -0,0 +1,59 @@
+package toolkit
+
+import (
+	"errors"
+	"testing"
+)
+
+var extensionTests = []struct {
+	name              string
+	fileName          string
+	allowedExtensions []string
+	data              []byte
+	errExpected       bool
+}{
+	{name: "png as png", fileName: "image.png", data: nil},
+	{name: "png in upper case", fileName: "IMAGE.PNG", data: nil},
+	{name: "png as html", fileName: "evil.html", data: nil, errExpected: true},
+	{name: "png with no extension", fileName: "image", data: nil, errExpected: true},
+	{name: "png not in allowed extensions", fileName: "image.png", allowedExtensions: []string{".jpg", ".jpeg"}, data: nil, errExpected: true},
+	{name: "png in allowed extensions", fileName: "image.png", allowedExtensions: []string{"JPG", "png"}, data: nil},
+	{name: "text as csv", fileName: "data.csv", data: []byte("a,b,c\n1,2,3\n")},
+	{name: "html as txt", fileName: "page.txt", data: []byte("<html><body>hello</body></html>"), errExpected: true},
+}
+
+func TestToolsUploadFileCheckExtension(t *testing.T) {
+	img := testPNG(t, 8, 8)
+	for _, e := range extensionTests {
+		data := e.data
+		if data == nil {
+			data = img
+		}
+		r := newMultipartRequest(t, testPart{field: "file", fileName: e.fileName, data: data})
+		testTools := Tools{CheckExtension: true, AllowedExtensions: e.allowedExtensions}
+
+		_, err := testTools.UploadOneFile(r, t.TempDir())
+		if !e.errExpected {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+
+		var mismatch *ExtensionMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("%s: expected an *ExtensionMismatchError, received %v", e.name, err)
+			continue
+		}
+		if !errors.Is(err, ErrFileTypeNotAllowed) {
+			t.Errorf("%s: expected the error to match ErrFileTypeNotAllowed", e.name)
+		}
+	}
+
+	// without CheckExtension the extension is not looked at
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "evil.html", data: img})
+	var testTools Tools
+	if _, err := testTools.UploadOneFile(r, t.TempDir()); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
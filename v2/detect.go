@@ -0,0 +1,275 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// detectLen is the number of bytes read from the start of a file when a DetectorRegistry is in use. It is more
+// than http.DetectContentType looks at so that the entries at the start of zip based documents can be inspected.
+const detectLen = 8192
+
+// Detector identifies the content type of a file from its first bytes. It returns "" if it does not
+// recognise the content.
+type Detector interface {
+	Detect(head []byte) string
+}
+
+// DetectorFunc adapts an ordinary function to the Detector interface.
+type DetectorFunc func(head []byte) string
+
+// Detect calls f(head).
+func (f DetectorFunc) Detect(head []byte) string {
+	return f(head)
+}
+
+// DetectorRegistry detects content types from a wider range of signatures than http.DetectContentType. It
+// tries the detectors added with Register, in the order they were added, then a built in table of signatures
+// and finally falls back to http.DetectContentType. The built in table recognises office documents (docx,
+// xlsx, pptx, odt, ods, odp and epub, by looking at the entries inside the zip), HEIC, HEIF and AVIF images,
+// SVG, TIFF, Parquet, 7z, xz and zstd. The zero value is ready to use and it is safe for concurrent use.
+type DetectorRegistry struct {
+	mu         sync.RWMutex
+	detectors  []Detector
+	extensions map[string]string
+}
+
+// Register adds a detector that is consulted before the built in signatures.
+func (d *DetectorRegistry) Register(detector Detector) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.detectors = append(d.detectors, detector)
+}
+
+// RegisterExtension records the content type of files with the extension ext, such as ".heic". It is used by
+// CheckExtension before mime.TypeByExtension is consulted.
+func (d *DetectorRegistry) RegisterExtension(ext, contentType string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.extensions == nil {
+		d.extensions = make(map[string]string)
+	}
+	d.extensions[strings.ToLower(ext)] = contentType
+}
+
+// Detect returns the content type of a file starting with head. It always returns a valid content type,
+// falling back to "application/octet-stream".
+func (d *DetectorRegistry) Detect(head []byte) string {
+	d.mu.RLock()
+	detectors := d.detectors
+	d.mu.RUnlock()
+
+	for _, detector := range detectors {
+		if ct := detector.Detect(head); ct != "" {
+			return ct
+		}
+	}
+	for _, s := range builtinSignatures {
+		if ct := s.detect(head); ct != "" {
+			return ct
+		}
+	}
+
+	return http.DetectContentType(head)
+}
+
+// TypeByExtension returns the content type of files with the extension ext, or "" if it is not known either
+// from RegisterExtension or the built in table.
+func (d *DetectorRegistry) TypeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+
+	d.mu.RLock()
+	ct, ok := d.extensions[ext]
+	d.mu.RUnlock()
+	if ok {
+		return ct
+	}
+
+	for _, s := range builtinSignatures {
+		for contentType, exts := range s.extensions {
+			for _, x := range exts {
+				if x == ext {
+					return contentType
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// detectContentType returns the content type of a file starting with head, using Detectors if it is set.
+func (t *Tools) detectContentType(head []byte) string {
+	if t.Detectors != nil {
+		return t.Detectors.Detect(head)
+	}
+	return http.DetectContentType(head)
+}
+
+// headLen is how many bytes from the start of a file detectContentType should be given.
+func (t *Tools) headLen() int {
+	if t.Detectors != nil {
+		return detectLen
+	}
+	return sniffLen
+}
+
+// signature is an entry in the built in table used by DetectorRegistry.
+type signature struct {
+	detect func(head []byte) string
+	// extensions maps the content types returned by detect to their file extensions.
+	extensions map[string][]string
+}
+
+const (
+	docxType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	xlsxType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	pptxType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	odtType  = "application/vnd.oasis.opendocument.text"
+	odsType  = "application/vnd.oasis.opendocument.spreadsheet"
+	odpType  = "application/vnd.oasis.opendocument.presentation"
+	epubType = "application/epub+zip"
+)
+
+var builtinSignatures = []signature{
+	{detect: detectZipDocument, extensions: map[string][]string{
+		docxType: {".docx"}, xlsxType: {".xlsx"}, pptxType: {".pptx"},
+		odtType: {".odt"}, odsType: {".ods"}, odpType: {".odp"}, epubType: {".epub"},
+	}},
+	{detect: detectISOBMFFImage, extensions: map[string][]string{
+		"image/heic": {".heic"}, "image/heif": {".heif"}, "image/avif": {".avif"},
+	}},
+	{detect: detectSVG, extensions: map[string][]string{"image/svg+xml": {".svg"}}},
+	{detect: magic("image/tiff", "II*\x00", "MM\x00*"), extensions: map[string][]string{"image/tiff": {".tif", ".tiff"}}},
+	{detect: magic("application/vnd.apache.parquet", "PAR1"), extensions: map[string][]string{"application/vnd.apache.parquet": {".parquet"}}},
+	{detect: magic("application/x-7z-compressed", "7z\xbc\xaf\x27\x1c"), extensions: map[string][]string{"application/x-7z-compressed": {".7z"}}},
+	{detect: magic("application/x-xz", "\xfd7zXZ\x00"), extensions: map[string][]string{"application/x-xz": {".xz"}}},
+	{detect: magic("application/zstd", "\x28\xb5\x2f\xfd"), extensions: map[string][]string{"application/zstd": {".zst"}}},
+}
+
+// magic returns a detect function reporting contentType for content that starts with any of prefixes.
+func magic(contentType string, prefixes ...string) func([]byte) string {
+	return func(head []byte) string {
+		for _, p := range prefixes {
+			if bytes.HasPrefix(head, []byte(p)) {
+				return contentType
+			}
+		}
+		return ""
+	}
+}
+
+// detectZipDocument recognises documents stored as zip files by walking the local file headers found in head.
+// OpenDocument and EPUB files start with an uncompressed entry called mimetype holding their content type, and
+// Office Open XML files have entries under word/, xl/ or ppt/.
+func detectZipDocument(head []byte) string {
+	const localHeaderLen = 30
+	localHeader := []byte("PK\x03\x04")
+
+	off := 0
+	for off+localHeaderLen <= len(head) && bytes.HasPrefix(head[off:], localHeader) {
+		flags := binary.LittleEndian.Uint16(head[off+6:])
+		method := binary.LittleEndian.Uint16(head[off+8:])
+		compressedSize := int(binary.LittleEndian.Uint32(head[off+18:]))
+		nameLen := int(binary.LittleEndian.Uint16(head[off+26:]))
+		extraLen := int(binary.LittleEndian.Uint16(head[off+28:]))
+
+		nameEnd := off + localHeaderLen + nameLen
+		if nameEnd > len(head) {
+			return ""
+		}
+		name := string(head[off+localHeaderLen : nameEnd])
+		dataStart := nameEnd + extraLen
+
+		switch {
+		case name == "mimetype" && method == 0 && dataStart < len(head):
+			// the size may only be given after the data, so compare against the known types directly
+			for _, ct := range []string{odtType, odsType, odpType, epubType} {
+				if bytes.HasPrefix(head[dataStart:], []byte(ct)) {
+					return ct
+				}
+			}
+		case strings.HasPrefix(name, "word/"):
+			return docxType
+		case strings.HasPrefix(name, "xl/"):
+			return xlsxType
+		case strings.HasPrefix(name, "ppt/"):
+			return pptxType
+		}
+
+		if flags&0x08 != 0 {
+			// the sizes follow the data, so look for the next header instead
+			if dataStart > len(head) {
+				return ""
+			}
+			next := bytes.Index(head[dataStart:], localHeader)
+			if next < 0 {
+				return ""
+			}
+			off = dataStart + next
+			continue
+		}
+		off = dataStart + compressedSize
+	}
+
+	return ""
+}
+
+// detectISOBMFFImage recognises HEIC, HEIF and AVIF images from the brands in their leading ftyp box.
+func detectISOBMFFImage(head []byte) string {
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	boxLen := int(binary.BigEndian.Uint32(head))
+	if boxLen < 16 || boxLen > len(head) {
+		boxLen = len(head)
+	}
+
+	// the major brand is followed by a version and then the compatible brands
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= boxLen; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	for _, b := range brands {
+		switch b {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic"
+		}
+	}
+	for _, b := range brands {
+		if b == "mif1" || b == "msf1" {
+			return "image/heif"
+		}
+	}
+	return ""
+}
+
+// detectSVG recognises SVG images: XML whose first element is svg.
+func detectSVG(head []byte) string {
+	s := bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	for {
+		s = bytes.TrimLeft(s, " \t\r\n")
+		var end []byte
+		switch {
+		case bytes.HasPrefix(s, []byte("<?")):
+			end = []byte("?>")
+		case bytes.HasPrefix(s, []byte("<!--")):
+			end = []byte("-->")
+		case bytes.HasPrefix(s, []byte("<!")):
+			end = []byte(">")
+		case len(s) > 4 && bytes.EqualFold(s[:4], []byte("<svg")) && strings.ContainsRune(" \t\r\n>/", rune(s[4])):
+			return "image/svg+xml"
+		default:
+			return ""
+		}
+		i := bytes.Index(s, end)
+		if i < 0 {
+			return ""
+		}
+		s = s[i+len(end):]
+	}
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testZip returns a zip archive holding an entry for each name, in order. An entry called mimetype is stored
+// uncompressed with content as its data, as OpenDocument requires.
+func testZip(t *testing.T, content string, names ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		method := zip.Deflate
+		data := "<xml/>"
+		if name == "mimetype" {
+			method, data = zip.Store, content
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testFtyp returns the start of an ISO base media file with the given major and compatible brands.
+func testFtyp(major string, compatible ...string) []byte {
+	box := make([]byte, 16, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(box, uint32(16+4*len(compatible)))
+	copy(box[4:], "ftyp"+major)
+	for _, c := range compatible {
+		box = append(box, c...)
+	}
+	return append(box, "\x00\x00\x00\x08mdat"...)
+}
+
+func TestDetectorRegistry(t *testing.T) {
+	detectTests := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{name: "docx", head: testZip(t, "", "[Content_Types].xml", "_rels/.rels", "word/document.xml"), expected: docxType},
+		{name: "xlsx", head: testZip(t, "", "[Content_Types].xml", "xl/workbook.xml"), expected: xlsxType},
+		{name: "pptx", head: testZip(t, "", "[Content_Types].xml", "ppt/presentation.xml"), expected: pptxType},
+		{name: "odt", head: testZip(t, odtType, "mimetype", "content.xml"), expected: odtType},
+		{name: "epub", head: testZip(t, epubType, "mimetype", "META-INF/container.xml"), expected: epubType},
+		{name: "plain zip", head: testZip(t, "", "a.txt", "b.txt"), expected: "application/zip"},
+		{name: "heic", head: testFtyp("heic", "mif1", "heic"), expected: "image/heic"},
+		{name: "heif", head: testFtyp("mif1", "mif1"), expected: "image/heif"},
+		{name: "avif", head: testFtyp("avif", "mif1", "avif"), expected: "image/avif"},
+		{name: "mp4 is left to net/http", head: testFtyp("mp42", "isom", "mp42"), expected: "video/mp4"},
+		{name: "svg", head: []byte(`<?xml version="1.0"?><!-- drawn by hand --><svg xmlns="http://www.w3.org/2000/svg"></svg>`), expected: "image/svg+xml"},
+		{name: "svg with doctype", head: []byte("\n<!DOCTYPE svg>\n<svg width=\"10\"/>"), expected: "image/svg+xml"},
+		{name: "other xml", head: []byte(`<?xml version="1.0"?><svgish/>`), expected: "text/xml; charset=utf-8"},
+		{name: "webp lossless", head: []byte("RIFF\x10\x00\x00\x00WEBPVP8L\x04\x00\x00\x00"), expected: "image/webp"},
+		{name: "tiff", head: []byte("II*\x00\x08\x00\x00\x00"), expected: "image/tiff"},
+		{name: "parquet", head: []byte("PAR1\x15\x04"), expected: "application/vnd.apache.parquet"},
+		{name: "png", head: testPNG(t, 4, 4), expected: "image/png"},
+	}
+
+	var registry DetectorRegistry
+	for _, e := range detectTests {
+		if got := registry.Detect(e.head); got != e.expected {
+			t.Errorf("%s: expected %s, received %s", e.name, e.expected, got)
+		}
+	}
+
+	registry.Register(DetectorFunc(func(head []byte) string {
+		if bytes.HasPrefix(head, []byte("PAR1")) {
+			return "application/x-custom"
+		}
+		return ""
+	}))
+	registry.RegisterExtension(".CUSTOM", "application/x-custom")
+	if got := registry.Detect([]byte("PAR1")); got != "application/x-custom" {
+		t.Errorf("expected a registered detector to take precedence, received %s", got)
+	}
+	if got := registry.TypeByExtension(".custom"); got != "application/x-custom" {
+		t.Errorf("expected the registered extension type, received %q", got)
+	}
+	if got := registry.TypeByExtension(".docx"); got != docxType {
+		t.Errorf("expected the built in extension type, received %q", got)
+	}
+}
+
+func TestToolsUploadFileDetectors(t *testing.T) {
+	docx := testZip(t, "", "[Content_Types].xml", "word/document.xml")
+
+	for _, e := range []struct {
+		name      string
+		detectors *DetectorRegistry
+		errExpect bool
+	}{
+		{name: "net/http only sees a zip", detectors: nil, errExpect: true},
+		{name: "registry sees a docx", detectors: &DetectorRegistry{}, errExpect: false},
+	} {
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "report.docx", data: docx})
+		testTools := Tools{AllowedTypes: []string{docxType}, Detectors: e.detectors, CheckExtension: true}
+		f, err := testTools.UploadOneFile(r, t.TempDir())
+		if e.errExpect {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if f.ContentType != docxType {
+			t.Errorf("%s: expected content type %s, received %s", e.name, docxType, f.ContentType)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxFileNameLength is the longest file name, in bytes, produced by SanitizeFileName. It is the limit of
+	// most file systems.
+	maxFileNameLength = 255
+	// maxExtensionLength is the longest extension, including the dot, kept by SanitizeFileName.
+	maxExtensionLength = 16
+	// maxCollisionSuffix is the highest numeric suffix tried by CollisionSuffix before giving up.
+	maxCollisionSuffix = 10000
+)
+
+// CollisionPolicy decides what happens when an uploaded file keeps its name and a file of that name already
+// exists in the upload directory.
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite replaces the existing file. This is the default.
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionFail rejects the upload with ErrFileExists.
+	CollisionFail
+	// CollisionSuffix appends -1, -2 and so on to the name until it is unique.
+	CollisionSuffix
+)
+
+// ErrFileExists is returned when OnCollision is CollisionFail and the name of an uploaded file is taken.
+var ErrFileExists = errors.New("a file with the same name already exists")
+
+var (
+	unsafeNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+	reservedNames   = regexp.MustCompile(`^(con|prn|aux|nul|com[0-9]|lpt[0-9])$`)
+)
+
+// SanitizeFileName turns a client supplied file name into one that is safe to use on any file system. Any
+// directory part is dropped, the rest of the name is lower cased and every run of characters other than
+// letters and digits becomes a single "-", in the same way as Slugify. The extension is kept if it is made of
+// letters and digits only, otherwise it is treated as part of the name. Names that are reserved on Windows
+// get "-file" appended, names that end up empty become "file" and long names are cut to 255 bytes.
+func (t *Tools) SanitizeFileName(name string) string {
+	return sanitizeFileName(name)
+}
+
+// sanitizeFileName is SanitizeFileName, for callers that have no Tools.
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	ext := path.Ext(name)
+	if ext == "." || unsafeNameChars.MatchString(strings.ToLower(strings.TrimPrefix(ext, "."))) {
+		// deleting the characters would turn an extension such as ".ph p" into ".php"
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	ext = strings.ToLower(ext)
+
+	if len(ext) > maxExtensionLength {
+		ext = ext[:maxExtensionLength]
+	}
+
+	stem = strings.Trim(unsafeNameChars.ReplaceAllString(strings.ToLower(stem), "-"), "-")
+	if stem == "" {
+		stem = "file"
+	}
+	if reservedNames.MatchString(stem) {
+		stem += "-file"
+	}
+
+	return truncateStem(stem, maxFileNameLength-len(ext)) + ext
+}
+
+// keptFileName returns the name to store a file under when it is not renamed: the sanitized original name,
+// adjusted according to OnCollision.
+func (t *Tools) keptFileName(uploadDir, original string) (string, error) {
+	name := t.SanitizeFileName(original)
+
+	switch t.OnCollision {
+	case CollisionFail:
+		exists, err := t.fileExists(uploadDir, name)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", fmt.Errorf("%w: %s", ErrFileExists, name)
+		}
+	case CollisionSuffix:
+		ext := path.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		for i := 1; ; i++ {
+			exists, err := t.fileExists(uploadDir, name)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				break
+			}
+			if i > maxCollisionSuffix {
+				return "", fmt.Errorf("%w: %s", ErrFileExists, name)
+			}
+			suffix := fmt.Sprintf("-%d", i)
+			name = truncateStem(stem, maxFileNameLength-len(ext)-len(suffix)) + suffix + ext
+		}
+	}
+
+	return name, nil
+}
+
+// commitKept commits tmp under the name f keeps, claiming the name with claimKept so that no other file is
+// overwritten.
+func (t *Tools) commitKept(uploadDir string, f *UploadedFile, tmp *os.File) error {
+	// Images may have changed the extension of the name chosen by keptFileName
+	original := strings.TrimSuffix(f.OriginalFileName, path.Ext(f.OriginalFileName)) + path.Ext(f.NewFileName)
+
+	name, err := t.claimKept(uploadDir, f.NewFileName, original, func(key string) error {
+		return t.commit(key, tmp, true)
+	})
+	if err != nil {
+		return err
+	}
+	f.NewFileName = name
+	return nil
+}
+
+// claimKept calls put with the storage key of name, which put must store exclusively, and returns the name
+// used. If name has been taken, for example by another upload since keptFileName chose it, it fails with
+// ErrFileExists or, with CollisionSuffix, tries again with the name keptFileName gives original.
+func (t *Tools) claimKept(uploadDir, name, original string, put func(key string) error) (string, error) {
+	for i := 0; ; i++ {
+		err := put(storageKey(uploadDir, name))
+		if !errors.Is(err, fs.ErrExist) {
+			return name, err
+		}
+		// every retry follows another upload taking a name, so keptFileName runs out of suffixes before this does
+		if t.OnCollision != CollisionSuffix || i == maxCollisionSuffix {
+			return "", fmt.Errorf("%w: %s", ErrFileExists, name)
+		}
+		if name, err = t.keptFileName(uploadDir, original); err != nil {
+			return "", err
+		}
+	}
+}
+
+// fileExists reports whether fileName is already stored in uploadDir.
+func (t *Tools) fileExists(uploadDir, fileName string) (bool, error) {
+	_, err := t.storage().Stat(storageKey(uploadDir, fileName))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// truncateStem cuts stem to at most n bytes, without leaving a trailing "-".
+func truncateStem(stem string, n int) string {
+	if len(stem) <= n {
+		return stem
+	}
+	return strings.TrimRight(stem[:n], "-")
+}
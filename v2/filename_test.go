@@ -0,0 +1,161 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name     string
+	fileName string
+	expected string
+}{
+	{name: "plain name", fileName: "photo.jpg", expected: "photo.jpg"},
+	{name: "mixed case and spaces", fileName: "My Holiday Photo (1).JPG", expected: "my-holiday-photo-1.jpg"},
+	{name: "parent directories", fileName: "../../etc/passwd", expected: "passwd"},
+	{name: "absolute path", fileName: "/var/www/index.html", expected: "index.html"},
+	{name: "windows path", fileName: `C:\Users\me\report.pdf`, expected: "report.pdf"},
+	{name: "control characters", fileName: "bad\x00name\r\n.txt", expected: "bad-name.txt"},
+	{name: "reserved name", fileName: "CON.txt", expected: "con-file.txt"},
+	{name: "nothing left", fileName: "สวัสดี.png", expected: "file.png"},
+	{name: "dot file", fileName: ".htaccess", expected: "file.htaccess"},
+	{name: "dots only", fileName: "..", expected: "file"},
+	{name: "unsafe extension", fileName: "script.p h?p", expected: "script-p-h-p"},
+	{name: "unsafe extension characters", fileName: "x.ph p", expected: "x-ph-p"},
+	{name: "upper case extension", fileName: "notes.TXT", expected: "notes.txt"},
+	{name: "long extension", fileName: "a." + strings.Repeat("x", 40), expected: "a." + strings.Repeat("x", 15)},
+}
+
+func TestToolsSanitizeFileName(t *testing.T) {
+	var testTools Tools
+	for _, e := range sanitizeTests {
+		if got := testTools.SanitizeFileName(e.fileName); got != e.expected {
+			t.Errorf("%s: expected %q, received %q", e.name, e.expected, got)
+		}
+	}
+
+	long := testTools.SanitizeFileName(strings.Repeat("a", 1000) + ".txt")
+	if len(long) != maxFileNameLength || !strings.HasSuffix(long, ".txt") {
+		t.Errorf("expected a long name to be cut to %d bytes keeping the extension, received %d bytes", maxFileNameLength, len(long))
+	}
+}
+
+func TestToolsUploadFileCollision(t *testing.T) {
+	img := testPNG(t, 8, 8)
+	upload := func(testTools *Tools, dir string) (*UploadedFile, error) {
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "../Avatar.PNG", data: img})
+		return testTools.UploadOneFile(r, dir, false)
+	}
+
+	dir := t.TempDir()
+	testTools := Tools{OnCollision: CollisionSuffix}
+	for i, expected := range []string{"avatar.png", "avatar-1.png", "avatar-2.png"} {
+		f, err := upload(&testTools, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.NewFileName != expected {
+			t.Errorf("upload %d: expected %s, received %s", i, expected, f.NewFileName)
+		}
+	}
+
+	testTools.OnCollision = CollisionFail
+	if _, err := upload(&testTools, dir); !errors.Is(err, ErrFileExists) {
+		t.Errorf("expected ErrFileExists, received %v", err)
+	}
+
+	testTools.OnCollision = CollisionOverwrite
+	f, err := upload(&testTools, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NewFileName != "avatar.png" {
+		t.Errorf("expected the file to be overwritten, received %s", f.NewFileName)
+	}
+}
+
+// staleStatStorage is a MemoryStorage whose Stat reports the first name it is asked about as missing, as if
+// another upload stored it just after the check.
+type staleStatStorage struct {
+	MemoryStorage
+	mu    sync.Mutex
+	stale bool
+}
+
+func (s *staleStatStorage) Stat(name string) (ObjectInfo, error) {
+	s.mu.Lock()
+	stale := !s.stale
+	s.stale = true
+	s.mu.Unlock()
+	if stale {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", name, fs.ErrNotExist)
+	}
+	return s.MemoryStorage.Stat(name)
+}
+
+func TestToolsUploadFileCollisionRace(t *testing.T) {
+	upload := func(testTools *Tools, dir string) (*UploadedFile, error) {
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "report.txt", data: []byte("figures")})
+		return testTools.UploadOneFile(r, dir, false)
+	}
+
+	for _, policy := range []CollisionPolicy{CollisionFail, CollisionSuffix} {
+		store := &staleStatStorage{}
+		store.Put("uploads/report.txt", strings.NewReader("first"))
+		testTools := Tools{Storage: store, OnCollision: policy}
+
+		f, err := upload(&testTools, "uploads")
+		switch policy {
+		case CollisionFail:
+			if !errors.Is(err, ErrFileExists) {
+				t.Errorf("expected ErrFileExists, received %v", err)
+			}
+		case CollisionSuffix:
+			if err != nil || f.NewFileName != "report-1.txt" {
+				t.Errorf("expected the next suffix to be used, received %v (%v)", f, err)
+			}
+		}
+		if rc, err := store.Open("uploads/report.txt"); err == nil {
+			b, _ := io.ReadAll(rc)
+			if string(b) != "first" {
+				t.Errorf("policy %d: expected the existing file to be left alone, found %q", policy, b)
+			}
+		}
+	}
+
+	// uploads of the same name at the same time must neither fail nor overwrite each other
+	dir := t.TempDir()
+	testTools := Tools{OnCollision: CollisionSuffix}
+	var wg sync.WaitGroup
+	names := make(chan string, 20)
+	for i := 0; i < cap(names); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := upload(&testTools, dir)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			names <- f.NewFileName
+		}()
+	}
+	wg.Wait()
+	close(names)
+	seen := make(map[string]bool)
+	for name := range names {
+		if seen[name] {
+			t.Errorf("%s was given to two uploads", name)
+		}
+		seen[name] = true
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != cap(names) {
+		t.Errorf("expected %d files, found %d", cap(names), len(entries))
+	}
+}
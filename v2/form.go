@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxFormValueSize is the most bytes of form values, other than files, read from a request. It is the same
+// limit net/http applies to forms.
+const maxFormValueSize = 10 << 20
+
+// UploadResult is returned by UploadForm.
+type UploadResult struct {
+	// Files are the uploaded files. The FieldName of each file is the form field it came from.
+	Files []*UploadedFile
+	// Values are the form fields that are not files.
+	Values url.Values
+}
+
+// FilesFor returns the files uploaded in the form field called field.
+func (u *UploadResult) FilesFor(field string) []*UploadedFile {
+	var files []*UploadedFile
+	for _, f := range u.Files {
+		if f.FieldName == field {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Decode copies Values into the struct pointed to by v. Each exported field is filled from the form value named
+// by its form tag, or by the field name if it has no tag, and a tag of "-" skips the field. Fields may be
+// strings, bools, numbers, types implementing encoding.TextUnmarshaler, or slices of these, which receive every
+// value of the form field. Form values with no matching struct field are ignored.
+func (u *UploadResult) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("decode needs a non nil pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("form"); ok {
+			name, _, _ = strings.Cut(tag, ",")
+		}
+		values, ok := u.Values[name]
+		if name == "-" || !ok || len(values) == 0 {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice && !isTextUnmarshaler(fv) {
+			s := reflect.MakeSlice(fv.Type(), len(values), len(values))
+			for j, value := range values {
+				if err := setFormValue(s.Index(j), value); err != nil {
+					return fmt.Errorf("form field %s: %w", name, err)
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := setFormValue(fv, values[0]); err != nil {
+			return fmt.Errorf("form field %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// isTextUnmarshaler reports whether a pointer to v implements encoding.TextUnmarshaler.
+func isTextUnmarshaler(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+// setFormValue parses s into v according to the kind of v.
+func setFormValue(v reflect.Value, s string) error {
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// readFormValue adds the content of part, a form field that is not a file, to values. It fails with
+// multipart.ErrMessageTooLarge once more than remaining bytes of values have been read in total.
+func readFormValue(part *multipart.Part, values url.Values, remaining *int64) error {
+	b, err := io.ReadAll(io.LimitReader(part, *remaining+1))
+	if err != nil {
+		return err
+	}
+	*remaining -= int64(len(b))
+	if *remaining < 0 {
+		return multipart.ErrMessageTooLarge
+	}
+	values.Add(part.FormName(), string(b))
+	return nil
+}
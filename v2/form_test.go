@@ -0,0 +1,64 @@
+package toolkit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToolsUploadForm(t *testing.T) {
+	var form struct {
+		Caption string    `form:"caption"`
+		AlbumID int64     `form:"album_id"`
+		Public  bool      `form:"public"`
+		Tags    []string  `form:"tag"`
+		Taken   time.Time `form:"taken"`
+		Ignored string    `form:"-"`
+	}
+
+	for _, stream := range []bool{false, true} {
+		r := newMultipartRequest(t,
+			testPart{field: "caption", data: []byte("At the beach")},
+			testPart{field: "album_id", data: []byte("42")},
+			testPart{field: "tag", data: []byte("sea")},
+			testPart{field: "photo", fileName: "beach.png", data: testPNG(t, 4, 4)},
+			testPart{field: "tag", data: []byte("sand")},
+			testPart{field: "public", data: []byte("true")},
+			testPart{field: "taken", data: []byte("2026-07-01T10:00:00Z")},
+			testPart{field: "thumbs", fileName: "small.png", data: testPNG(t, 2, 2)},
+		)
+
+		testTools := Tools{StreamUploads: stream}
+		result, err := testTools.UploadForm(r, t.TempDir())
+		if err != nil {
+			t.Fatalf("stream %v: unexpected error %v", stream, err)
+		}
+
+		if photos := result.FilesFor("photo"); len(photos) != 1 || photos[0].OriginalFileName != "beach.png" {
+			t.Errorf("stream %v: expected beach.png in photo, received %v", stream, photos)
+		}
+		if thumbs := result.FilesFor("thumbs"); len(thumbs) != 1 || thumbs[0].FieldName != "thumbs" {
+			t.Errorf("stream %v: expected one file in thumbs, received %v", stream, thumbs)
+		}
+
+		if err := result.Decode(&form); err != nil {
+			t.Fatalf("stream %v: unexpected decode error %v", stream, err)
+		}
+		if form.Caption != "At the beach" || form.AlbumID != 42 || !form.Public {
+			t.Errorf("stream %v: unexpected form %+v", stream, form)
+		}
+		if len(form.Tags) != 2 || form.Tags[0] != "sea" || form.Tags[1] != "sand" {
+			t.Errorf("stream %v: expected both tags in order, received %v", stream, form.Tags)
+		}
+		if !form.Taken.Equal(time.Date(2026, 7, 1, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("stream %v: unexpected time %v", stream, form.Taken)
+		}
+	}
+
+	result := &UploadResult{Values: map[string][]string{"album_id": {"not a number"}}}
+	if err := result.Decode(&form); err == nil {
+		t.Error("expected an error decoding an invalid number")
+	}
+	if err := result.Decode(form); err == nil {
+		t.Error("expected an error decoding into a struct that is not a pointer")
+	}
+}
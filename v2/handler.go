@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// ErrBadMultipart is matched by the errors UploadFile returns for requests that are not valid multipart forms.
+var ErrBadMultipart = errors.New("the request is not a valid multipart form")
+
+// badMultipart wraps an error from the multipart reader in ErrBadMultipart, unless it is caused by a limit or
+// by the request being cancelled.
+func badMultipart(err error) error {
+	if errors.Is(err, ErrRequestTooLarge) || errors.Is(err, multipart.ErrMessageTooLarge) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrBadMultipart, err)
+}
+
+// UploadErrorStatus returns the HTTP status that suits an error returned by UploadFile:
+//
+//   - the status of an error with a StatusCode() int method, such as *QuotaError
+//   - 413 Request Entity Too Large for files, requests and images that are too large
+//   - 415 Unsupported Media Type for files whose type is not permitted
+//   - 422 Unprocessable Entity for infected files
+//   - 409 Conflict for files that already exist when OnCollision is CollisionFail
+//   - 400 Bad Request for malformed multipart forms and files in the wrong fields or too many of them
+//   - 500 Internal Server Error for anything else
+func UploadErrorStatus(err error) int {
+	var sc interface{ StatusCode() int }
+	var malwareErr *MalwareError
+
+	switch {
+	case errors.As(err, &sc):
+		return sc.StatusCode()
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrRequestTooLarge), errors.Is(err, ErrImageTooLarge),
+		errors.Is(err, multipart.ErrMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &malwareErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadMultipart), errors.Is(err, ErrFieldNotAllowed), errors.Is(err, ErrTooManyFiles),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UploadResponse is the Data of the JSONResponse written by UploadHandler when an upload succeeds:
+//
+//	{
+//		"error": false,
+//		"message": "2 files uploaded",
+//		"data": {
+//			"files": [{"new_file_name": "...", "original_file_name": "...", "file_size": 1024, ...}],
+//			"fields": {"caption": ["..."]}
+//		}
+//	}
+//
+// When files fail, "error" is true and "errors" lists them as in
+// {"original_file_name": "...", "reason": "too_large", "message": "..."}. The status is 207 Multi-Status if
+// some files were kept, because KeepPartial is set, and otherwise the status from UploadErrorStatus. Any
+// other failure is sent as {"error": true, "message": "..."}.
+type UploadResponse struct {
+	Files  []*UploadedFile `json:"files"`
+	Fields url.Values      `json:"fields,omitempty"`
+	Errors []*FileError    `json:"errors,omitempty"`
+}
+
+// UploadHandler is an http.Handler that uploads the files posted to it with UploadForm and replies with JSON.
+type UploadHandler struct {
+	// Tools is used to upload the files. If nil a zero Tools is used.
+	Tools     *Tools
+	UploadDir string
+	// KeepFileName stores files under their sanitized original names instead of random ones.
+	KeepFileName bool
+	// Status is the status sent when the upload succeeds. The default is 201 Created.
+	Status int
+	// OnUpload, if set, is called with the result before the response is written. If it returns an error the
+	// uploaded files are removed and the error is sent instead.
+	OnUpload func(r *http.Request, result *UploadResult) error
+}
+
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := h.Tools
+	if t == nil {
+		t = &Tools{}
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("allow", "POST, PUT")
+		t.ErrorJSON(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := t.UploadForm(r, h.UploadDir, !h.KeepFileName)
+	var multiErr *MultiUploadError
+	errors.As(err, &multiErr)
+	partial := multiErr != nil && len(result.Files) > 0
+	if err == nil && len(result.Files) == 0 {
+		err = fmt.Errorf("%w: no files were uploaded", ErrBadMultipart)
+	}
+	if (err == nil || partial) && h.OnUpload != nil {
+		if uerr := h.OnUpload(r, result); uerr != nil {
+			for _, f := range result.Files {
+				t.removeUploaded(h.UploadDir, f)
+			}
+			result.Files = nil
+			err, multiErr, partial = uerr, nil, false
+		}
+	}
+
+	switch {
+	case err == nil:
+		status := h.Status
+		if status == 0 {
+			status = http.StatusCreated
+		}
+		t.WriteJSON(w, status, JSONResponse{
+			Message: fmt.Sprintf("%d files uploaded", len(result.Files)),
+			Data:    UploadResponse{Files: result.Files, Fields: result.Values},
+		})
+	case multiErr != nil:
+		status := UploadErrorStatus(err)
+		if partial {
+			status = http.StatusMultiStatus
+		}
+		t.WriteJSON(w, status, JSONResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    UploadResponse{Files: result.Files, Fields: result.Values, Errors: multiErr.Files},
+		})
+	default:
+		// the reply does not list the files stored before the error, so they are not kept
+		for _, f := range result.Files {
+			t.removeUploaded(h.UploadDir, f)
+		}
+		t.ErrorJSON(w, err, UploadErrorStatus(err))
+	}
+}
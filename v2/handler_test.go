@@ -0,0 +1,115 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler(t *testing.T) {
+	png := testPNG(t, 8, 8)
+
+	handlerTests := []struct {
+		name     string
+		request  func() *http.Request
+		onUpload func(*http.Request, *UploadResult) error
+		status   int
+		files    int
+	}{
+		{name: "success", status: http.StatusCreated, files: 1, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "caption", data: []byte("hi")}, testPart{field: "file", fileName: "a.png", data: png})
+		}},
+		{name: "too large", status: http.StatusRequestEntityTooLarge, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "file", fileName: "a.png", data: testPNG(t, 64, 64)})
+		}},
+		{name: "type not allowed", status: http.StatusUnsupportedMediaType, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: []byte("text")})
+		}},
+		{name: "mixed types", status: http.StatusUnsupportedMediaType, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "file", fileName: "a.png", data: png}, testPart{field: "file", fileName: "b.txt", data: []byte("text")})
+		}},
+		{name: "not multipart", status: http.StatusBadRequest, request: func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("plain"))
+		}},
+		{name: "truncated", status: http.StatusBadRequest, request: func() *http.Request {
+			r := newMultipartRequest(t, testPart{field: "file", fileName: "a.png", data: png})
+			body := make([]byte, r.ContentLength-100)
+			io.ReadFull(r.Body, body)
+			r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+			return r
+		}},
+		{name: "no files", status: http.StatusBadRequest, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "caption", data: []byte("hi")})
+		}},
+		{name: "method", status: http.StatusMethodNotAllowed, request: func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/", nil)
+		}},
+		{name: "on upload fails", status: http.StatusInternalServerError, onUpload: func(*http.Request, *UploadResult) error {
+			return errors.New("database is down")
+		}, request: func() *http.Request {
+			return newMultipartRequest(t, testPart{field: "file", fileName: "a.png", data: png})
+		}},
+	}
+
+	for _, stream := range []bool{false, true} {
+		for _, e := range handlerTests {
+			uploadDir := t.TempDir()
+			h := &UploadHandler{
+				Tools:     &Tools{StreamUploads: stream, AllowedTypes: []string{"image/png"}, FieldRules: map[string]FieldRule{"file": {MaxFileSize: 4096}}},
+				UploadDir: uploadDir,
+				OnUpload:  e.onUpload,
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, e.request())
+			if rr.Code != e.status {
+				t.Errorf("stream %v, %s: expected status %d, received %d (%s)", stream, e.name, e.status, rr.Code, rr.Body)
+				continue
+			}
+
+			var res struct {
+				Error bool           `json:"error"`
+				Data  UploadResponse `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Errorf("stream %v, %s: invalid JSON: %v", stream, e.name, err)
+				continue
+			}
+			if res.Error != (e.status >= 400) || len(res.Data.Files) != e.files {
+				t.Errorf("stream %v, %s: unexpected response %+v", stream, e.name, res)
+			}
+			if e.files > 0 && (res.Data.Files[0].OriginalFileName != "a.png" || res.Data.Fields.Get("caption") != "hi") {
+				t.Errorf("stream %v, %s: unexpected data %+v", stream, e.name, res.Data)
+			}
+			if entries, _ := os.ReadDir(uploadDir); len(entries) != e.files {
+				t.Errorf("stream %v, %s: expected %d stored files, found %d", stream, e.name, e.files, len(entries))
+			}
+		}
+	}
+}
+
+func TestUploadErrorStatus(t *testing.T) {
+	for _, e := range []struct {
+		err    error
+		status int
+	}{
+		{err: fmt.Errorf("saving: %w", ErrFileTooLarge), status: http.StatusRequestEntityTooLarge},
+		{err: &QuotaError{Owner: "alice"}, status: http.StatusRequestEntityTooLarge},
+		{err: &ExtensionMismatchError{}, status: http.StatusUnsupportedMediaType},
+		{err: &MalwareError{}, status: http.StatusUnprocessableEntity},
+		{err: ErrFileExists, status: http.StatusConflict},
+		{err: ErrTooManyFiles, status: http.StatusBadRequest},
+		{err: errors.New("disk full"), status: http.StatusInternalServerError},
+	} {
+		if got := UploadErrorStatus(e.err); got != e.status {
+			t.Errorf("%v: expected %d, received %d", e.err, e.status, got)
+		}
+	}
+}
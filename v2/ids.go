@@ -0,0 +1,316 @@
+package toolkit
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDFormat is a kind of identifier made by NewID.
+type IDFormat int
+
+const (
+	// IDRandom is 25 random lowercase letters and digits, as made by RandomFileName. This is the default.
+	IDRandom IDFormat = iota
+	// IDUUIDv4 is a random UUID.
+	IDUUIDv4
+	// IDUUIDv7 is a UUID that starts with the time it was made, so that later IDs sort after earlier ones.
+	IDUUIDv7
+	// IDULID is a ULID, which is time sortable like IDUUIDv7 but written as 26 Crockford base32 characters.
+	IDULID
+)
+
+// ErrInvalidUUID is returned by ParseUUID when its argument is not a UUID.
+var ErrInvalidUUID = errors.New("the string is not a valid UUID")
+
+// ErrInvalidULID is returned by ParseULID when its argument is not a ULID.
+var ErrInvalidULID = errors.New("the string is not a valid ULID")
+
+// NewID returns a new identifier of the given format as a string.
+func NewID(format IDFormat) (string, error) {
+	return newIDAt(format, time.Now())
+}
+
+// newIDAt is NewID with the time used by the time sortable formats.
+func newIDAt(format IDFormat, now time.Time) (string, error) {
+	switch format {
+	case IDRandom:
+		return RandomFileName("")
+	case IDUUIDv4:
+		u, err := NewUUIDv4()
+		return u.String(), err
+	case IDUUIDv7:
+		u, err := newUUIDv7At(now)
+		return u.String(), err
+	case IDULID:
+		u, err := newULIDAt(now)
+		return u.String(), err
+	default:
+		return "", fmt.Errorf("unknown ID format %d", format)
+	}
+}
+
+// UUID is a universally unique identifier as described in RFC 9562.
+type UUID [16]byte
+
+// NewUUIDv4 returns a random UUID.
+func NewUUIDv4() (UUID, error) {
+	var u UUID
+	if err := readRandom(u[:]); err != nil {
+		return UUID{}, err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// NewUUIDv7 returns a UUID that starts with the current Unix time in milliseconds followed by random bits.
+// UUIDs made within the same millisecond by the same process are still in increasing order, as the random
+// bits are incremented rather than drawn again.
+func NewUUIDv7() (UUID, error) {
+	return newUUIDv7At(time.Now())
+}
+
+// uuidV7s keeps the 12 bit rand_a and 62 bit rand_b fields of the last UUIDv7.
+var uuidV7s = &monotonic{hiBits: 12, loBits: 62}
+
+func newUUIDv7At(now time.Time) (UUID, error) {
+	ms, hi, lo, err := uuidV7s.next(now)
+	if err != nil {
+		return UUID{}, err
+	}
+
+	var u UUID
+	putMillis(u[:], ms)
+	binary.BigEndian.PutUint16(u[6:], 0x7000|uint16(hi))
+	binary.BigEndian.PutUint64(u[8:], 0x8000000000000000|lo)
+	return u, nil
+}
+
+// ParseUUID parses a UUID in its canonical form, such as "0192a3e4-5b6c-7d8e-9fa0-b1c2d3e4f5a6". Upper case
+// hexadecimal is accepted.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return UUID{}, ErrInvalidUUID
+	}
+	// each group is decoded where it has to be, so that hyphens anywhere else are not hex digits
+	b := u[:]
+	for _, g := range [][2]int{{0, 8}, {9, 13}, {14, 18}, {19, 23}, {24, 36}} {
+		n, err := hex.Decode(b, []byte(s[g[0]:g[1]]))
+		if err != nil {
+			return UUID{}, ErrInvalidUUID
+		}
+		b = b[n:]
+	}
+	return u, nil
+}
+
+// String returns the UUID in its canonical lower case form.
+func (u UUID) String() string {
+	b := make([]byte, 36)
+	hex.Encode(b, u[:4])
+	b[8] = '-'
+	hex.Encode(b[9:], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b)
+}
+
+// Version returns the version of the UUID, such as 4 or 7.
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// Time returns the time a version 7 UUID was made, to the millisecond. It returns the zero time for other
+// versions.
+func (u UUID) Time() time.Time {
+	if u.Version() != 7 {
+		return time.Time{}
+	}
+	return time.UnixMilli(getMillis(u[:]))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *UUID) UnmarshalText(b []byte) error {
+	v, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// ULID is a universally unique lexicographically sortable identifier: a 48 bit Unix time in milliseconds
+// followed by 80 random bits.
+type ULID [16]byte
+
+// NewULID returns a ULID for the current time. ULIDs made within the same millisecond by the same process are
+// still in increasing order, as the random bits are incremented rather than drawn again.
+func NewULID() (ULID, error) {
+	return newULIDAt(time.Now())
+}
+
+// ulids keeps the 80 random bits of the last ULID.
+var ulids = &monotonic{hiBits: 16, loBits: 64}
+
+func newULIDAt(now time.Time) (ULID, error) {
+	ms, hi, lo, err := ulids.next(now)
+	if err != nil {
+		return ULID{}, err
+	}
+
+	var u ULID
+	putMillis(u[:], ms)
+	binary.BigEndian.PutUint16(u[6:], uint16(hi))
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+// ParseULID parses a ULID of 26 Crockford base32 characters. Lower case is accepted, as are I and L for 1 and
+// O for 0.
+func ParseULID(s string) (ULID, error) {
+	// the first character holds only the top 3 bits of the 128
+	if len(s) != 26 || crockfordValue(s[0]) > 7 {
+		return ULID{}, ErrInvalidULID
+	}
+
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := crockfordValue(s[i])
+		if v < 0 {
+			return ULID{}, ErrInvalidULID
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+
+	var u ULID
+	binary.BigEndian.PutUint64(u[:], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+// String returns the ULID as 26 upper case Crockford base32 characters.
+func (u ULID) String() string {
+	hi, lo := binary.BigEndian.Uint64(u[:]), binary.BigEndian.Uint64(u[8:])
+	b := make([]byte, 26)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = AlphabetCrockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b)
+}
+
+// Time returns the time the ULID was made, to the millisecond.
+func (u ULID) Time() time.Time {
+	return time.UnixMilli(getMillis(u[:]))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *ULID) UnmarshalText(b []byte) error {
+	v, err := ParseULID(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// crockfordValue returns the value of a Crockford base32 character, or -1 if c is not one.
+func crockfordValue(c byte) int {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	switch c {
+	case 'I', 'L':
+		c = '1'
+	case 'O':
+		c = '0'
+	}
+	return strings.IndexByte(string(AlphabetCrockford), c)
+}
+
+// monotonic makes the random part of time sortable IDs, split into a high and a low word of hiBits and loBits
+// bits. A new millisecond draws new random bits. Within the same millisecond, or if the clock goes back, the
+// previous bits are incremented instead, and if they overflow the time is moved on by a millisecond.
+type monotonic struct {
+	hiBits, loBits uint
+
+	mu     sync.Mutex
+	ms     int64
+	hi, lo uint64
+}
+
+func (m *monotonic) next(now time.Time) (ms int64, hi, lo uint64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t := now.UnixMilli(); t > m.ms {
+		b := make([]byte, 16)
+		if err := readRandom(b); err != nil {
+			return 0, 0, 0, err
+		}
+		m.ms = t
+		m.hi = binary.BigEndian.Uint64(b) & mask(m.hiBits)
+		m.lo = binary.BigEndian.Uint64(b[8:]) & mask(m.loBits)
+		return m.ms, m.hi, m.lo, nil
+	}
+
+	if m.lo = (m.lo + 1) & mask(m.loBits); m.lo == 0 {
+		if m.hi = (m.hi + 1) & mask(m.hiBits); m.hi == 0 {
+			m.ms++
+		}
+	}
+	return m.ms, m.hi, m.lo, nil
+}
+
+// mask returns a mask of the lowest n bits.
+func mask(n uint) uint64 {
+	return 1<<n - 1
+}
+
+// readRandom fills b from crypto/rand.
+func readRandom(b []byte) error {
+	r := randomReaders.Get().(io.Reader)
+	defer randomReaders.Put(r)
+	_, err := io.ReadFull(r, b)
+	return err
+}
+
+// putMillis writes the lowest 48 bits of ms to the start of b.
+func putMillis(b []byte, ms int64) {
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+// getMillis reads a 48 bit time in milliseconds from the start of b.
+func getMillis(b []byte) int64 {
+	var ms int64
+	for _, c := range b[:6] {
+		ms = ms<<8 | int64(c)
+	}
+	return ms
+}
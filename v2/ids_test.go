@@ -0,0 +1,149 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freshMonotonic replaces the state of the time sortable IDs for the length of a test, so that the times
+// used by other tests do not carry over.
+func freshMonotonic(t *testing.T) {
+	t.Helper()
+
+	v7s, us := uuidV7s, ulids
+	uuidV7s, ulids = &monotonic{hiBits: 12, loBits: 62}, &monotonic{hiBits: 16, loBits: 64}
+	t.Cleanup(func() { uuidV7s, ulids = v7s, us })
+}
+
+func TestUUID(t *testing.T) {
+	freshMonotonic(t)
+
+	u, err := NewUUIDv4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Version() != 4 || u[8]&0xc0 != 0x80 {
+		t.Errorf("unexpected version or variant in %s", u)
+	}
+	if !u.Time().IsZero() {
+		t.Errorf("expected no time for a version 4 UUID, received %v", u.Time())
+	}
+
+	now := time.UnixMilli(1760572800123)
+	v7, err := newUUIDv7At(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v7.Version() != 7 || v7[8]&0xc0 != 0x80 {
+		t.Errorf("unexpected version or variant in %s", v7)
+	}
+	if !v7.Time().Equal(now) {
+		t.Errorf("expected the time %v, received %v", now, v7.Time())
+	}
+
+	parsed, err := ParseUUID(strings.ToUpper(v7.String()))
+	if err != nil || parsed != v7 {
+		t.Errorf("expected %s to parse back, received %s (%v)", v7, parsed, err)
+	}
+	for _, s := range []string{"", "0192a3e4-5b6c-7d8e-9fa0-b1c2d3e4f5a", "0192a3e45b6c7d8e9fa0b1c2d3e4f5a6", "0192a3e4-5b6c-7d8e-9fa0-b1c2d3e4f5ag",
+		"--92a3e4-5b6c-7d8e-9fa0-b1c2d3e4f5a6", "0192a3e4-5b6c-7d8e-9fa0-b1c2d3e4f5-6", "0192a3e4-5b-c-7d8e-9fa0-b1c2d3e4f5a6", "0192a3e4-5b6c-7d8e-9fa0--1c2d3e4f5a6"} {
+		if _, err := ParseUUID(s); !errors.Is(err, ErrInvalidUUID) {
+			t.Errorf("%q: expected ErrInvalidUUID, received %v", s, err)
+		}
+	}
+
+	b, err := json.Marshal(struct{ ID UUID }{v7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct{ ID UUID }
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.ID != v7 {
+		t.Errorf("expected %s to round trip through JSON, received %s (%v)", v7, decoded.ID, err)
+	}
+}
+
+func TestULID(t *testing.T) {
+	freshMonotonic(t)
+
+	// the example from the ULID specification
+	u, err := ParseULID("01aryz6s41tsv4rrffq69g5fav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.String() != "01ARYZ6S41TSV4RRFFQ69G5FAV" {
+		t.Errorf("expected the canonical form, received %s", u)
+	}
+	if ms := u.Time().UnixMilli(); ms != 1469918176385 {
+		t.Errorf("expected the time 1469918176385, received %d", ms)
+	}
+	for _, s := range []string{"O1ARYZ6S41TSV4RRFFQ69G5FAV", "o1aryz6s41tsv4rrffq69g5fav", "oLaryz6s4itsv4rrffq69g5fav"} {
+		if parsed, err := ParseULID(s); err != nil || parsed != u {
+			t.Errorf("%q: expected the aliases of 0 and 1 to be accepted, received %s (%v)", s, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "91ARZ3NDEKTSV4RRFFQ69G5FAV", "U1ARZ3NDEKTSV4RRFFQ69G5FAV", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if _, err := ParseULID(s); !errors.Is(err, ErrInvalidULID) {
+			t.Errorf("%q: expected ErrInvalidULID, received %v", s, err)
+		}
+	}
+
+	now := time.UnixMilli(1760572800123)
+	u, err = newULIDAt(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Time().Equal(now) {
+		t.Errorf("expected the time %v, received %v", now, u.Time())
+	}
+	if parsed, err := ParseULID(u.String()); err != nil || parsed != u {
+		t.Errorf("expected %s to parse back, received %s (%v)", u, parsed, err)
+	}
+}
+
+func TestMonotonicIDs(t *testing.T) {
+	freshMonotonic(t)
+
+	// the clock stands still and then goes back, so every ID after the first is made by incrementing
+	now := time.UnixMilli(1760572800123)
+	for _, format := range []IDFormat{IDUUIDv7, IDULID} {
+		prev := ""
+		for i := 0; i < 1000; i++ {
+			at := now
+			if i > 500 {
+				at = now.Add(-time.Second)
+			}
+			id, err := newIDAt(format, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id <= prev {
+				t.Fatalf("format %d: %s does not sort after %s", format, id, prev)
+			}
+			prev = id
+		}
+	}
+
+	m := &monotonic{hiBits: 12, loBits: 62, ms: 1000, hi: mask(12), lo: mask(62)}
+	ms, hi, lo, err := m.next(time.UnixMilli(1000))
+	if err != nil || ms != 1001 || hi != 0 || lo != 0 {
+		t.Errorf("expected an overflow to move on to the next millisecond, received %d %d %d (%v)", ms, hi, lo, err)
+	}
+}
+
+func TestToolsUploadFileRenameID(t *testing.T) {
+	uploadDir := t.TempDir()
+	testTools := Tools{Rename: RenameID(IDULID)}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "report.txt", data: []byte("hello")})
+	f, err := testTools.UploadOneFile(r, uploadDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ext, _ := strings.Cut(f.NewFileName, ".")
+	if _, err := ParseULID(id); err != nil || ext != "txt" {
+		t.Errorf("expected a ULID followed by .txt, received %s (%v)", f.NewFileName, err)
+	}
+}
@@ -0,0 +1,461 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrImageTooLarge is returned when an uploaded image has more pixels than MaxPixels of the ImagePipeline.
+var ErrImageTooLarge = errors.New("the uploaded image has too many pixels")
+
+// ImageFormat is a format images can be encoded in by an ImagePipeline.
+type ImageFormat string
+
+const (
+	// ImageFormatOriginal keeps JPEG and PNG images in their format. Other images are encoded as PNG.
+	ImageFormatOriginal ImageFormat = ""
+	ImageFormatJPEG     ImageFormat = "jpeg"
+	ImageFormatPNG      ImageFormat = "png"
+)
+
+// ThumbnailSize describes a thumbnail made by an ImagePipeline. The image is scaled down to fit within Width
+// and Height, keeping its aspect ratio. A zero Width or Height does not limit that side. Images are never
+// scaled up.
+type ThumbnailSize struct {
+	// Name is added to the name of the uploaded file to name the thumbnail, as in photo-small.jpg.
+	Name   string
+	Width  int
+	Height int
+}
+
+// ImageVariant is an image derived from an uploaded file by an ImagePipeline.
+type ImageVariant struct {
+	Name        string `json:"name"`
+	FileName    string `json:"file_name"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	FileSize    int64  `json:"file_size"`
+}
+
+// ImagePipeline processes uploaded JPEG, PNG and GIF images before they are stored. Set it as Images in Tools.
+// Images are only decoded and encoded again when an option needs it, which drops all of their metadata.
+// Animated GIFs are always stored as they are, since encoding them again would keep only their first frame,
+// and their thumbnails are made from the first frame.
+type ImagePipeline struct {
+	// AutoOrient rotates and flips JPEG images as their EXIF orientation says, so that they display the right
+	// way up without it.
+	AutoOrient bool
+	// StripMetadata encodes every image again to remove EXIF data such as GPS coordinates. The orientation is
+	// applied first.
+	StripMetadata bool
+	// Format is the format the image is stored in. Changing the format changes the extension of the stored file.
+	Format ImageFormat
+	// Quality is the JPEG quality from 1 to 100. The default is 85.
+	Quality int
+	// Thumbnails are stored next to the image. Their names and sizes are recorded in the Variants of the
+	// UploadedFile.
+	Thumbnails []ThumbnailSize
+	// MaxPixels is the largest width times height of an image that is decoded. Larger images are rejected with
+	// ErrImageTooLarge. The default is 50 million.
+	MaxPixels int
+}
+
+// handles reports whether images of contentType are processed by p. It is false for a nil p.
+func (p *ImagePipeline) handles(contentType string) bool {
+	if p == nil {
+		return false
+	}
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// encodedImage is an image encoded by an ImagePipeline.
+type encodedImage struct {
+	data        []byte
+	contentType string
+	ext         string
+	width       int
+	height      int
+}
+
+// process decodes the image read from r and returns it encoded again, or nil if the image is to be stored as
+// it is, along with its thumbnails in the order of Thumbnails.
+func (p *ImagePipeline) process(r io.Reader, contentType string) (*encodedImage, []*encodedImage, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	maxPixels := p.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = 50_000_000
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, nil, ErrImageTooLarge
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = exifOrientation(raw)
+	}
+	format := p.format(contentType)
+
+	animated := contentType == "image/gif" && gifFrames(raw) > 1
+	reencode := !animated && (p.StripMetadata || (p.AutoOrient && orientation != 1) || format != formatOf(contentType))
+	if !reencode && len(p.Thumbnails) == 0 {
+		return nil, nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	img = orient(img, orientation)
+
+	var main *encodedImage
+	if reencode {
+		if main, err = p.encode(img, format); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	thumbs := make([]*encodedImage, 0, len(p.Thumbnails))
+	for _, size := range p.Thumbnails {
+		thumb, err := p.encode(resize(img, size.Width, size.Height), format)
+		if err != nil {
+			return nil, nil, err
+		}
+		thumbs = append(thumbs, thumb)
+	}
+
+	return main, thumbs, nil
+}
+
+// format returns the format images of contentType are encoded in.
+func (p *ImagePipeline) format(contentType string) ImageFormat {
+	if p.Format != ImageFormatOriginal {
+		return p.Format
+	}
+	if contentType == "image/jpeg" {
+		return ImageFormatJPEG
+	}
+	return ImageFormatPNG
+}
+
+// formatOf returns the ImageFormat of contentType, or ImageFormatOriginal if it is not JPEG or PNG.
+func formatOf(contentType string) ImageFormat {
+	switch contentType {
+	case "image/jpeg":
+		return ImageFormatJPEG
+	case "image/png":
+		return ImageFormatPNG
+	}
+	return ImageFormatOriginal
+}
+
+// encode encodes img in format. Transparent areas are made white in JPEG images.
+func (p *ImagePipeline) encode(img image.Image, format ImageFormat) (*encodedImage, error) {
+	var buf bytes.Buffer
+	b := img.Bounds()
+	e := &encodedImage{width: b.Dx(), height: b.Dy()}
+
+	switch format {
+	case ImageFormatJPEG:
+		quality := p.Quality
+		if quality <= 0 || quality > 100 {
+			quality = 85
+		}
+		flat := image.NewRGBA(b)
+		draw.Draw(flat, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, b, img, b.Min, draw.Over)
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		e.contentType, e.ext = "image/jpeg", ".jpg"
+	case ImageFormatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		e.contentType, e.ext = "image/png", ".png"
+	default:
+		return nil, fmt.Errorf("unknown image format %q", format)
+	}
+
+	e.data = buf.Bytes()
+	return e, nil
+}
+
+// processImage runs the Images pipeline over the spooled file tmp. If the image is encoded again, the new
+// content replaces tmp, the checksums, size and content type of f are updated, and the extension of the new
+// format is returned. The thumbnails are returned to be stored once f has been named.
+func (t *Tools) processImage(tmp *os.File, f *UploadedFile, uploadDir string) (*os.File, string, []*encodedImage, error) {
+	main, thumbs, err := t.Images.process(tmp, f.ContentType)
+	if err != nil {
+		return tmp, "", nil, err
+	}
+	if main == nil {
+		_, err := tmp.Seek(0, io.SeekStart)
+		return tmp, "", thumbs, err
+	}
+
+	sums := t.newChecksums()
+	processed, size, err := t.spool(uploadDir, io.TeeReader(bytes.NewReader(main.data), sums))
+	if err != nil {
+		return tmp, "", nil, err
+	}
+	discardSpool(tmp)
+
+	sums.apply(f)
+	f.FileSize = size
+	f.ContentType = main.contentType
+
+	return processed, main.ext, thumbs, nil
+}
+
+// saveVariants stores the thumbnails of f, named after it, and records them in its Variants. If exclusive is set
+// f keeps its name, and the thumbnails are stored with claimKept so that they follow OnCollision too.
+func (t *Tools) saveVariants(uploadDir string, f *UploadedFile, thumbs []*encodedImage, exclusive bool) error {
+	stem := strings.TrimSuffix(f.NewFileName, filepath.Ext(f.NewFileName))
+	for i, thumb := range thumbs {
+		v := ImageVariant{
+			Name:        t.Images.Thumbnails[i].Name,
+			FileName:    stem + "-" + t.Images.Thumbnails[i].Name + thumb.ext,
+			Width:       thumb.width,
+			Height:      thumb.height,
+			ContentType: thumb.contentType,
+			FileSize:    int64(len(thumb.data)),
+		}
+		switch {
+		case f.Duplicate:
+		case exclusive:
+			name, err := t.claimKept(uploadDir, v.FileName, v.FileName, func(key string) error {
+				_, err := t.storage().Put(key, bytes.NewReader(thumb.data), PutExclusive)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			v.FileName = name
+		default:
+			if _, err := t.storage().Put(storageKey(uploadDir, v.FileName), bytes.NewReader(thumb.data)); err != nil {
+				return err
+			}
+		}
+		f.Variants = append(f.Variants, v)
+	}
+	return nil
+}
+
+// exifOrientation returns the EXIF orientation, from 1 to 8, of a JPEG image, or 1 if it has none.
+func exifOrientation(jpg []byte) int {
+	if len(jpg) < 4 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(jpg); {
+		if jpg[i] != 0xff {
+			return 1
+		}
+		marker := jpg[i+1]
+		if marker == 0xda || marker == 0xd9 { // start of scan or end of image: no more metadata
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(jpg[i+2:]))
+		if size < 2 || i+2+size > len(jpg) {
+			return 1
+		}
+		segment := jpg[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+
+	return 1
+}
+
+// gifFrames returns the number of frames in a GIF image, counting no further than 2. It walks the blocks of the
+// file without decoding them, so that an animation with many frames costs nothing to detect.
+func gifFrames(gif []byte) int {
+	const header = 13 // signature, version and logical screen descriptor
+	if len(gif) < header || !bytes.HasPrefix(gif, []byte("GIF")) {
+		return 0
+	}
+	i := header
+	if gif[10]&0x80 != 0 {
+		i += 3 << (gif[10]&0x07 + 1)
+	}
+
+	frames := 0
+	for i < len(gif) && frames < 2 {
+		switch gif[i] {
+		case 0x21: // extension: a label followed by data sub-blocks
+			i += 2
+		case 0x2c: // image descriptor, an optional local colour table, the LZW code size and data sub-blocks
+			if i+10 > len(gif) {
+				return frames
+			}
+			frames++
+			if flags := gif[i+9]; flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i += 11
+		default: // trailer, or a damaged file
+			return frames
+		}
+		// skip the data sub-blocks, each of which starts with its size and which end with an empty one
+		for i < len(gif) && gif[i] != 0 {
+			i += int(gif[i]) + 1
+		}
+		i++
+	}
+	return frames
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF structure holding EXIF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
+
+// orient returns img rotated and flipped according to an EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
+
+// resize scales img down to fit within width and height, averaging the pixels each new pixel covers. A zero
+// width or height does not limit that side.
+func resize(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	scale := 1.0
+	if width > 0 && w > width {
+		scale = float64(width) / float64(w)
+	}
+	if height > 0 && h > height && float64(height)/float64(h) < scale {
+		scale = float64(height) / float64(h)
+	}
+	if scale == 1.0 {
+		return img
+	}
+
+	dw, dh := int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// the sums are of premultiplied colours, so the average is too
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+
+	return dst
+}
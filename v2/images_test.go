@@ -0,0 +1,218 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testJPEG returns a w x h JPEG image carrying an EXIF orientation and a GPS IFD pointer.
+func testJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x02")
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // orientation
+	tiff = append(tiff, 0, 3, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x8825) // GPS IFD
+	tiff = append(tiff, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xff, 0xd8, 0xff, 0xe1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		if got := exifOrientation(testJPEG(t, 4, 2, o)); got != o {
+			t.Errorf("expected orientation %d, received %d", o, got)
+		}
+	}
+	if got := exifOrientation(testPNG(t, 2, 2)); got != 1 {
+		t.Errorf("expected orientation 1 for a PNG, received %d", got)
+	}
+}
+
+// testGIF returns a w x h GIF image with the given number of frames.
+func testGIF(t *testing.T, w, h, frames int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{}
+	palette := color.Palette{color.Black, color.White, color.RGBA{0xff, 0, 0, 0xff}}
+	for i := 0; i < frames; i++ {
+		img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for x := 0; x < w; x++ {
+			img.SetColorIndex(x, (x+i)%h, uint8(i%len(palette)))
+		}
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestToolsUploadFileImages(t *testing.T) {
+	uploadDir := t.TempDir()
+	testTools := Tools{
+		Images: &ImagePipeline{
+			AutoOrient:    true,
+			StripMetadata: true,
+			Thumbnails:    []ThumbnailSize{{Name: "small", Width: 10}, {Name: "large", Width: 1000, Height: 1000}},
+		},
+	}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "photo.jpg", data: testJPEG(t, 40, 20, 6)})
+	f, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(uploadDir, f.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("Exif")) {
+		t.Error("expected the metadata to be stripped")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 40 {
+		t.Errorf("expected the image to be rotated to 20x40, received %dx%d", cfg.Width, cfg.Height)
+	}
+	if f.FileSize != int64(len(b)) {
+		t.Errorf("expected the size of the processed image, %d, received %d", len(b), f.FileSize)
+	}
+
+	if len(f.Variants) != 2 {
+		t.Fatalf("expected 2 variants, received %d", len(f.Variants))
+	}
+	for i, e := range []struct{ w, h int }{{10, 20}, {20, 40}} {
+		v := f.Variants[i]
+		if v.Width != e.w || v.Height != e.h {
+			t.Errorf("%s: expected %dx%d, received %dx%d", v.Name, e.w, e.h, v.Width, v.Height)
+		}
+		if _, err := os.Stat(filepath.Join(uploadDir, v.FileName)); err != nil {
+			t.Errorf("%s: expected the variant to be stored: %v", v.Name, err)
+		}
+	}
+
+	testTools.Images = &ImagePipeline{Format: ImageFormatPNG}
+	testTools.ContentAddressed = true
+	r = newMultipartRequest(t, testPart{field: "file", fileName: "photo.JPG", data: testJPEG(t, 8, 8, 1)})
+	f, err = testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ContentType != "image/png" || filepath.Ext(f.NewFileName) != ".png" || f.NewFileName != f.SHA256+".png" {
+		t.Errorf("expected a content addressed PNG, received %s as %s", f.NewFileName, f.ContentType)
+	}
+	b, _ = os.ReadFile(filepath.Join(uploadDir, f.NewFileName))
+	if _, err := png.Decode(bytes.NewReader(b)); err != nil {
+		t.Errorf("expected a PNG file: %v", err)
+	}
+
+	testTools.Images = &ImagePipeline{MaxPixels: 100}
+	r = newMultipartRequest(t, testPart{field: "file", fileName: "big.png", data: testPNG(t, 20, 20)})
+	if _, err := testTools.UploadOneFile(r, uploadDir); err != ErrImageTooLarge {
+		t.Errorf("expected ErrImageTooLarge, received %v", err)
+	}
+}
+
+func TestToolsUploadFileAnimatedGIF(t *testing.T) {
+	for frames, expected := range map[int]int{1: 1, 2: 2, 5: 2} {
+		if n := gifFrames(testGIF(t, 16, 8, frames)); n != expected {
+			t.Errorf("expected %d frames to be counted as %d, received %d", frames, expected, n)
+		}
+	}
+	if n := gifFrames([]byte("GIF89a")); n != 0 {
+		t.Errorf("expected a truncated GIF to have no frames, received %d", n)
+	}
+
+	uploadDir := t.TempDir()
+	testTools := Tools{Images: &ImagePipeline{StripMetadata: true, Thumbnails: []ThumbnailSize{{Name: "small", Width: 4}}}}
+
+	anim := testGIF(t, 16, 8, 3)
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "anim.gif", data: anim})
+	f, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(uploadDir, f.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, anim) || f.ContentType != "image/gif" || filepath.Ext(f.NewFileName) != ".gif" {
+		t.Errorf("expected an animated GIF to be stored unchanged, received %s as %s", f.NewFileName, f.ContentType)
+	}
+	if len(f.Variants) != 1 || f.Variants[0].Width != 4 || f.Variants[0].Height != 2 {
+		t.Errorf("expected a thumbnail of the first frame, received %+v", f.Variants)
+	}
+
+	r = newMultipartRequest(t, testPart{field: "file", fileName: "still.gif", data: testGIF(t, 16, 8, 1)})
+	if f, err = testTools.UploadOneFile(r, uploadDir); err != nil {
+		t.Fatal(err)
+	}
+	if f.ContentType != "image/png" {
+		t.Errorf("expected a single frame GIF to be encoded again as PNG, received %s", f.ContentType)
+	}
+}
+
+func TestToolsUploadFileVariantCollision(t *testing.T) {
+	for _, policy := range []CollisionPolicy{CollisionFail, CollisionSuffix} {
+		uploadDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(uploadDir, "photo-small.png"), []byte("kept"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		testTools := Tools{OnCollision: policy, Images: &ImagePipeline{Thumbnails: []ThumbnailSize{{Name: "small", Width: 4}}}}
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "photo.png", data: testPNG(t, 16, 16)})
+		f, err := testTools.UploadOneFile(r, uploadDir, false)
+		switch policy {
+		case CollisionFail:
+			if !errors.Is(err, ErrFileExists) {
+				t.Errorf("expected ErrFileExists, received %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(uploadDir, "photo.png")); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected the image to be removed with its thumbnail, received %v", err)
+			}
+		case CollisionSuffix:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.NewFileName != "photo.png" || len(f.Variants) != 1 || f.Variants[0].FileName != "photo-small-1.png" {
+				t.Errorf("expected the thumbnail to take the next suffix, received %s %+v", f.NewFileName, f.Variants)
+			}
+		}
+		if b, _ := os.ReadFile(filepath.Join(uploadDir, "photo-small.png")); string(b) != "kept" {
+			t.Errorf("policy %d: expected the existing file to be left alone, found %q", policy, b)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrTTLWithoutMetadata is returned by UploadFile when TTL is set but Metadata is not, as expiry times are
+// recorded in the metadata and files would otherwise never expire.
+var ErrTTLWithoutMetadata = errors.New("TTL is set but there is no Metadata to record expiry times in")
+
+// checkTTL returns ErrTTLWithoutMetadata if TTL is set without Metadata.
+func (t *Tools) checkTTL() error {
+	if t.TTL > 0 && t.Metadata == nil {
+		return ErrTTLWithoutMetadata
+	}
+	return nil
+}
+
+// ExpiredFile is a file found to have expired by a Janitor.
+type ExpiredFile struct {
+	Name string
+	Size int64
+	// ExpiresAt is when the file expired, from its metadata or from its age.
+	ExpiresAt time.Time
+}
+
+// SweepReport describes what a Janitor found in one sweep.
+type SweepReport struct {
+	// DryRun is true if the expired files were only reported and not removed.
+	DryRun bool
+	// Expired are the expired files, which have been removed unless DryRun is set or removing them failed.
+	Expired []ExpiredFile
+	// Bytes is the total size of Expired.
+	Bytes int64
+}
+
+// Janitor removes expired uploads from Dir in the Storage of Tools, along with their metadata and image
+// variants, and frees their space in the Quota of their uploader if it is known from their metadata. A file
+// has expired when the ExpiresAt in its metadata has passed, which Tools records when TTL is set, or, if
+// MaxAge is set, when it was stored more than MaxAge ago. The time is read from the Now of Tools.
+type Janitor struct {
+	Tools *Tools
+	Dir   string
+	// Interval is the time between sweeps after Start. The default is one hour.
+	Interval time.Duration
+	// MaxAge, if set, also expires files without an ExpiresAt once they are older than MaxAge.
+	MaxAge time.Duration
+	// DryRun makes sweeps report what has expired without removing anything.
+	DryRun bool
+	// OnSweep, if set, is called with the report and error of every sweep made after Start.
+	OnSweep func(SweepReport, error)
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start sweeps Dir every Interval on a new goroutine until Stop is called. It does nothing if the Janitor has
+// already been started.
+func (j *Janitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		return
+	}
+
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	j.stop, j.done = make(chan struct{}), make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report, err := j.Sweep()
+				if j.OnSweep != nil {
+					j.OnSweep(report, err)
+				}
+			}
+		}
+	}(j.stop, j.done)
+}
+
+// Stop stops the sweeps started by Start and waits for a sweep in progress to finish.
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	stop, done := j.stop, j.done
+	j.stop, j.done = nil, nil
+	j.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Sweep finds the expired files in Dir and, unless DryRun is set, removes them. Errors removing one file do
+// not stop the others from being removed, and are returned together. Files still being uploaded and the
+// Variants of other files are left alone, the Variants being removed along with the file they belong to.
+func (j *Janitor) Sweep() (SweepReport, error) {
+	report := SweepReport{DryRun: j.DryRun}
+	t := j.Tools
+	if t == nil {
+		t = &Tools{}
+	}
+	now := t.now()
+
+	prefix := path.Clean(filepath.ToSlash(j.Dir)) + "/"
+	objects, err := t.storage().List(prefix)
+	if err != nil {
+		return report, err
+	}
+
+	var errs []error
+	var files []ObjectInfo
+	metadata := make(map[string]FileMetadata)
+	// skip holds the Variants of other files and files whose metadata cannot be read, which cannot be known to
+	// have expired
+	skip := make(map[string]bool)
+	for _, o := range objects {
+		if IsSidecar(o.Name) || isTempFile(o.Name) {
+			continue
+		}
+		files = append(files, o)
+		if t.Metadata == nil {
+			continue
+		}
+
+		m, err := t.Metadata.Load(o.Name)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+				skip[o.Name] = true
+			}
+			continue
+		}
+		metadata[o.Name] = m
+		for _, v := range m.Variants {
+			skip[storageKey(path.Dir(o.Name), v)] = true
+		}
+	}
+
+	for _, o := range files {
+		if skip[o.Name] {
+			continue
+		}
+
+		m, hasMetadata := metadata[o.Name]
+		expiresAt := m.ExpiresAt
+		if expiresAt.IsZero() && j.MaxAge > 0 {
+			expiresAt = o.ModTime.Add(j.MaxAge)
+		}
+		if expiresAt.IsZero() || expiresAt.After(now) {
+			continue
+		}
+
+		report.Expired = append(report.Expired, ExpiredFile{Name: o.Name, Size: o.Size, ExpiresAt: expiresAt})
+		report.Bytes += o.Size
+		if j.DryRun {
+			continue
+		}
+
+		if err := t.storage().Delete(o.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+			continue
+		}
+		for _, v := range m.Variants {
+			t.storage().Delete(storageKey(path.Dir(o.Name), v))
+		}
+		if t.Quota != nil && hasMetadata {
+			t.Quota.Add(m.Uploader, -o.Size)
+		}
+		if t.Metadata != nil {
+			if err := t.Metadata.Delete(o.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+			}
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
@@ -0,0 +1,200 @@
+package toolkit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	uploadDir := t.TempDir()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	quota := &MemoryQuota{Limit: 1 << 20}
+	testTools := Tools{Metadata: &SidecarStore{}, Quota: quota, TTL: time.Hour, Now: func() time.Time { return now }}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "preview.png", data: testPNG(t, 4, 4)})
+	preview, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !preview.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the upload to expire in an hour, received %v", preview.ExpiresAt)
+	}
+
+	testTools.TTL = 0
+	r = newMultipartRequest(t, testPart{field: "file", fileName: "keep.png", data: testPNG(t, 4, 4)})
+	kept, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := &Janitor{Tools: &testTools, Dir: uploadDir, DryRun: true}
+	report, err := janitor.Sweep()
+	if err != nil || len(report.Expired) != 0 {
+		t.Fatalf("expected nothing to have expired yet, received %+v (%v)", report, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	report, err = janitor.Sweep()
+	if err != nil || len(report.Expired) != 1 || !report.DryRun || report.Bytes != preview.FileSize {
+		t.Fatalf("expected the preview to be reported, received %+v (%v)", report, err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, preview.NewFileName)); err != nil {
+		t.Error("expected a dry run to leave the file alone")
+	}
+
+	janitor.DryRun = false
+	if _, err := janitor.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(uploadDir)
+	if len(entries) != 2 || entries[0].Name() != kept.NewFileName && entries[1].Name() != kept.NewFileName {
+		t.Errorf("expected only the kept file and its sidecar to remain, found %v", entries)
+	}
+	if remaining, _ := quota.Remaining(""); remaining != 1<<20-kept.FileSize {
+		t.Errorf("expected the space of the preview to be freed, %d bytes remain", remaining)
+	}
+
+	janitor.MaxAge = 24 * time.Hour
+	now = time.Now().Add(25 * time.Hour)
+	if report, err := janitor.Sweep(); err != nil || len(report.Expired) != 1 {
+		t.Errorf("expected the kept file to expire by age, received %+v (%v)", report, err)
+	}
+	if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+		t.Errorf("expected every file to be removed, found %v", entries)
+	}
+}
+
+func TestJanitorMaxAge(t *testing.T) {
+	uploadDir := t.TempDir()
+	now := time.Now().Add(48 * time.Hour)
+	testTools := Tools{
+		Metadata: &SidecarStore{},
+		Images:   &ImagePipeline{Thumbnails: []ThumbnailSize{{Name: "small", Width: 2}}},
+		Now:      func() time.Time { return now },
+	}
+
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "photo.png", data: testPNG(t, 4, 4)})
+	photo, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photo.Variants) != 1 {
+		t.Fatalf("expected a thumbnail, received %+v", photo.Variants)
+	}
+	inFlight := filepath.Join(uploadDir, tempFilePrefix+"123")
+	if err := os.WriteFile(inFlight, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := &Janitor{Tools: &testTools, Dir: uploadDir, MaxAge: 24 * time.Hour}
+	report, err := janitor.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Expired) != 1 || report.Expired[0].Name != storageKey(uploadDir, photo.NewFileName) {
+		t.Errorf("expected only the photo to be reported, received %+v", report.Expired)
+	}
+	entries, _ := os.ReadDir(uploadDir)
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(inFlight) {
+		t.Errorf("expected only the upload in progress to remain, found %v", entries)
+	}
+
+	// without Tools the local file system is swept at the current time
+	old := time.Now().Add(-48 * time.Hour)
+	stale := filepath.Join(uploadDir, "stale.txt")
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{stale, inFlight} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	janitor = &Janitor{Dir: uploadDir, MaxAge: 24 * time.Hour}
+	if report, err := janitor.Sweep(); err != nil || len(report.Expired) != 1 {
+		t.Errorf("expected the stale file to expire, received %+v (%v)", report, err)
+	}
+	if _, err := os.Stat(inFlight); err != nil {
+		t.Errorf("expected the upload in progress to be left alone (%v)", err)
+	}
+}
+
+func TestToolsUploadFileDuplicateExpiry(t *testing.T) {
+	uploadDir := t.TempDir()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	testTools := Tools{ContentAddressed: true, Metadata: &SidecarStore{}, TTL: time.Hour, Now: func() time.Time { return now }}
+
+	upload := func() *UploadedFile {
+		t.Helper()
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "report.txt", data: []byte("same content")})
+		f, err := testTools.UploadOneFile(r, uploadDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	expiresAt := func(f *UploadedFile) time.Time {
+		t.Helper()
+		m, err := testTools.Metadata.Load(storageKey(uploadDir, f.NewFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.ExpiresAt
+	}
+
+	first := upload()
+	now = now.Add(50 * time.Minute)
+	second := upload()
+	if !second.Duplicate {
+		t.Fatal("expected the second upload to be a duplicate")
+	}
+	if got := expiresAt(first); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the expiry to be extended to %v, received %v", now.Add(time.Hour), got)
+	}
+
+	testTools.TTL = 10 * time.Minute
+	upload()
+	if got := expiresAt(first); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected an earlier expiry to be ignored, received %v", got)
+	}
+
+	testTools.TTL = 0
+	upload()
+	if got := expiresAt(first); !got.IsZero() {
+		t.Errorf("expected an upload without TTL to keep the content for good, received %v", got)
+	}
+}
+
+func TestToolsUploadFileTTLWithoutMetadata(t *testing.T) {
+	uploadDir := t.TempDir()
+	testTools := Tools{TTL: time.Hour}
+	r := newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: []byte("text")})
+	if _, err := testTools.UploadOneFile(r, uploadDir); err != ErrTTLWithoutMetadata {
+		t.Errorf("expected ErrTTLWithoutMetadata, received %v", err)
+	}
+	if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+		t.Errorf("expected nothing to be stored, found %d files", len(entries))
+	}
+}
+
+func TestJanitorStartStop(t *testing.T) {
+	swept := make(chan SweepReport, 10)
+	janitor := &Janitor{
+		Tools:    &Tools{},
+		Dir:      t.TempDir(),
+		Interval: time.Millisecond,
+		OnSweep:  func(r SweepReport, err error) { swept <- r },
+	}
+
+	janitor.Start()
+	janitor.Start()
+	select {
+	case <-swept:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a sweep after Start")
+	}
+	janitor.Stop()
+	janitor.Stop()
+}
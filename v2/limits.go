@@ -0,0 +1,75 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrRequestTooLarge is returned by UploadFile when the request body is larger than MaxRequestSize.
+var ErrRequestTooLarge = errors.New("the request is too large")
+
+// ErrTooManyFiles is returned by UploadFile when a form field carries more files than its FieldRule allows.
+var ErrTooManyFiles = errors.New("too many files uploaded")
+
+// ErrFieldNotAllowed is returned by UploadFile when a file is uploaded in a form field that has no FieldRule.
+var ErrFieldNotAllowed = errors.New("files are not permitted in this field")
+
+// FieldRule limits the files uploaded in one form field. Zero values fall back to the settings of Tools.
+type FieldRule struct {
+	// MaxFiles is the number of files the field may carry. Zero means there is no limit.
+	MaxFiles int
+	// MaxFileSize is the largest size in bytes of each file. Zero keeps the limit that applies without a rule.
+	MaxFileSize int64
+	// AllowedTypes replaces AllowedTypes of Tools for this field when it is not empty.
+	AllowedTypes []string
+}
+
+// partFor returns how the n-th file of field, called fileName, is to be saved, with limit as the default size
+// limit. It fails if FieldRules does not permit the file.
+func (t *Tools) partFor(field, fileName string, limit int64, n int) (uploadPart, error) {
+	p := uploadPart{field: field, fileName: fileName, limit: limit, allowedTypes: t.AllowedTypes}
+	if len(t.FieldRules) == 0 {
+		return p, nil
+	}
+
+	rule, ok := t.FieldRules[field]
+	if !ok {
+		return p, fmt.Errorf("%w: %q", ErrFieldNotAllowed, field)
+	}
+	if rule.MaxFiles > 0 && n > rule.MaxFiles {
+		return p, fmt.Errorf("%w: at most %d in %q", ErrTooManyFiles, rule.MaxFiles, field)
+	}
+	if rule.MaxFileSize > 0 {
+		p.limit = rule.MaxFileSize
+	}
+	if len(rule.AllowedTypes) > 0 {
+		p.allowedTypes = rule.AllowedTypes
+	}
+
+	return p, nil
+}
+
+// limitRequestBody makes reading the body of r fail with ErrRequestTooLarge once more than MaxRequestSize
+// bytes have been read. Requests that declare a larger Content-Length are rejected straight away.
+func (t *Tools) limitRequestBody(r *http.Request) error {
+	if t.MaxRequestSize <= 0 {
+		return nil
+	}
+	if r.ContentLength > t.MaxRequestSize {
+		return ErrRequestTooLarge
+	}
+
+	r.Body = &limitedBody{maxBytesReader: maxBytesReader{r: r.Body, n: t.MaxRequestSize, err: ErrRequestTooLarge}, c: r.Body}
+	return nil
+}
+
+// limitedBody is a maxBytesReader that can be used as a request body.
+type limitedBody struct {
+	maxBytesReader
+	c interface{ Close() error }
+}
+
+func (b *limitedBody) Close() error {
+	return b.c.Close()
+}
@@ -0,0 +1,65 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestToolsUploadFileFieldRules(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n%fake document\n")
+	rules := map[string]FieldRule{
+		"avatar":      {MaxFiles: 1, MaxFileSize: 2048, AllowedTypes: []string{"image/png"}},
+		"attachments": {MaxFiles: 2, AllowedTypes: []string{"application/pdf"}},
+	}
+
+	limitTests := []struct {
+		name        string
+		parts       []testPart
+		errExpected error
+	}{
+		{name: "allowed", parts: []testPart{{field: "avatar", fileName: "me.png", data: testPNG(t, 8, 8)}, {field: "attachments", fileName: "a.pdf", data: pdf}}},
+		{name: "field not allowed", parts: []testPart{{field: "other", fileName: "a.pdf", data: pdf}}, errExpected: ErrFieldNotAllowed},
+		{name: "too many files", parts: []testPart{{field: "attachments", fileName: "a.pdf", data: pdf}, {field: "attachments", fileName: "b.pdf", data: pdf}, {field: "attachments", fileName: "c.pdf", data: pdf}}, errExpected: ErrTooManyFiles},
+		{name: "file too large for field", parts: []testPart{{field: "avatar", fileName: "me.png", data: testPNG(t, 64, 64)}}, errExpected: ErrFileTooLarge},
+		{name: "type not allowed for field", parts: []testPart{{field: "avatar", fileName: "a.pdf", data: pdf}}, errExpected: ErrFileTypeNotAllowed},
+		{name: "form fields are not limited", parts: []testPart{{field: "comment", data: []byte("hello")}, {field: "attachments", fileName: "a.pdf", data: pdf}}},
+	}
+
+	for _, stream := range []bool{false, true} {
+		for _, e := range limitTests {
+			testTools := Tools{StreamUploads: stream, FieldRules: rules}
+			_, err := testTools.UploadFile(newMultipartRequest(t, e.parts...), t.TempDir())
+			if e.errExpected == nil && err != nil {
+				t.Errorf("stream %v, %s: unexpected error %v", stream, e.name, err)
+			}
+			if e.errExpected != nil && !errors.Is(err, e.errExpected) {
+				t.Errorf("stream %v, %s: expected %v, received %v", stream, e.name, e.errExpected, err)
+			}
+		}
+	}
+}
+
+func TestToolsUploadFileMaxRequestSize(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	for _, stream := range []bool{false, true} {
+		testTools := Tools{StreamUploads: stream, MaxRequestSize: 1024}
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: data})
+		if _, err := testTools.UploadFile(r, t.TempDir()); !errors.Is(err, ErrRequestTooLarge) {
+			t.Errorf("stream %v: expected ErrRequestTooLarge from the content length, received %v", stream, err)
+		}
+
+		r = newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: data})
+		r.ContentLength = -1
+		if _, err := testTools.UploadFile(r, t.TempDir()); err == nil {
+			t.Errorf("stream %v: expected an error when the body is too large", stream)
+		}
+
+		r = newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: data[:100]})
+		if _, err := testTools.UploadFile(r, t.TempDir()); err != nil {
+			t.Errorf("stream %v: unexpected error %v", stream, err)
+		}
+	}
+}
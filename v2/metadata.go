@@ -0,0 +1,169 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metadataSuffix is added to the name of an uploaded file to name its SidecarStore metadata.
+const metadataSuffix = ".meta.json"
+
+// FileMetadata is what is known about an uploaded file beyond its content. It is saved in the MetadataStore
+// of Tools when the file is uploaded.
+type FileMetadata struct {
+	OriginalFileName string    `json:"original_file_name"`
+	ContentType      string    `json:"content_type"`
+	FileSize         int64     `json:"file_size"`
+	SHA256           string    `json:"sha256"`
+	MD5              string    `json:"md5,omitempty"`
+	CRC32C           string    `json:"crc32c,omitempty"`
+	Uploader         string    `json:"uploader,omitempty"`
+	UploadedAt       time.Time `json:"uploaded_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	// Variants are the file names of the image variants of the file, stored next to it.
+	Variants []string `json:"variants,omitempty"`
+}
+
+// MetadataStore keeps the FileMetadata of uploaded files. name is the name of the file in the Storage, that is
+// uploadDir/fileName. Load returns an error matching fs.ErrNotExist if there is no metadata for name.
+type MetadataStore interface {
+	Save(name string, m FileMetadata) error
+	Load(name string) (FileMetadata, error)
+	Delete(name string) error
+}
+
+// SidecarStore is a MetadataStore that saves metadata as JSON next to each file, named after the file with
+// ".meta.json" added. Storage must be the Storage of Tools, or nil for the local file system.
+type SidecarStore struct {
+	Storage Storage
+}
+
+func (s *SidecarStore) storage() Storage {
+	if s.Storage != nil {
+		return s.Storage
+	}
+	return &LocalStorage{}
+}
+
+// Save writes m to the sidecar of name.
+func (s *SidecarStore) Save(name string, m FileMetadata) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = s.storage().Put(name+metadataSuffix, bytes.NewReader(b))
+	return err
+}
+
+// Load reads the sidecar of name.
+func (s *SidecarStore) Load(name string) (FileMetadata, error) {
+	var m FileMetadata
+
+	rc, err := s.storage().Open(name + metadataSuffix)
+	if err != nil {
+		return m, err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Delete removes the sidecar of name.
+func (s *SidecarStore) Delete(name string) error {
+	return s.storage().Delete(name + metadataSuffix)
+}
+
+// IsSidecar reports whether name is the name of a file written by a SidecarStore.
+func IsSidecar(name string) bool {
+	return strings.HasSuffix(name, metadataSuffix)
+}
+
+// metadataOf returns the FileMetadata of f.
+func metadataOf(f *UploadedFile) FileMetadata {
+	m := FileMetadata{
+		OriginalFileName: f.OriginalFileName,
+		ContentType:      f.ContentType,
+		FileSize:         f.FileSize,
+		SHA256:           f.SHA256,
+		MD5:              f.MD5,
+		CRC32C:           f.CRC32C,
+		Uploader:         f.Uploader,
+		UploadedAt:       f.UploadedAt,
+		ExpiresAt:        f.ExpiresAt,
+	}
+	for _, v := range f.Variants {
+		m.Variants = append(m.Variants, v.FileName)
+	}
+	return m
+}
+
+// recordUpload sets the Uploader, UploadedAt and ExpiresAt of f and saves its metadata, if Metadata is set. The metadata
+// of Duplicates is left as it was saved by the first upload of their content, apart from its ExpiresAt which is
+// extended so that the content lasts as long as the latest upload of it.
+func (t *Tools) recordUpload(uploadDir string, f *UploadedFile, uploader string) error {
+	f.Uploader = uploader
+	f.UploadedAt = t.now().UTC()
+	if t.TTL > 0 {
+		f.ExpiresAt = f.UploadedAt.Add(t.TTL)
+	}
+
+	if t.Metadata == nil {
+		return nil
+	}
+	if f.Duplicate {
+		return t.extendExpiry(storageKey(uploadDir, f.NewFileName), f.ExpiresAt)
+	}
+	if err := t.Metadata.Save(storageKey(uploadDir, f.NewFileName), metadataOf(f)); err != nil {
+		t.removeUploaded(uploadDir, f)
+		return err
+	}
+	return nil
+}
+
+// extendExpiry moves the ExpiresAt saved for name to expiresAt if that is later. A zero expiresAt means the
+// file never expires, so it clears any ExpiresAt.
+func (t *Tools) extendExpiry(name string, expiresAt time.Time) error {
+	m, err := t.Metadata.Load(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if m.ExpiresAt.IsZero() || (!expiresAt.IsZero() && !expiresAt.After(m.ExpiresAt)) {
+		return nil
+	}
+
+	m.ExpiresAt = expiresAt
+	return t.Metadata.Save(name, m)
+}
+
+// now returns the current time according to Now.
+func (t *Tools) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// loadMetadata returns the metadata of the file at pathname, if Metadata is set and holds any.
+func (t *Tools) loadMetadata(pathname string) (FileMetadata, bool) {
+	if t.Metadata == nil {
+		return FileMetadata{}, false
+	}
+	// a file without readable metadata is still served, just without its original name and type
+	m, err := t.Metadata.Load(path.Clean(filepath.ToSlash(pathname)))
+	return m, err == nil
+}
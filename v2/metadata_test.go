@@ -0,0 +1,96 @@
+package toolkit
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolsUploadFileMetadata(t *testing.T) {
+	for _, store := range []Storage{nil, &MemoryStorage{}} {
+		uploadDir := t.TempDir()
+		testTools := Tools{
+			Storage:  store,
+			Metadata: &SidecarStore{Storage: store},
+			Uploader: func(r *http.Request) string { return r.Header.Get("x-user") },
+		}
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "Quarterly Report.txt", data: []byte("figures")})
+		r.Header.Set("x-user", "alice")
+		f, err := testTools.UploadOneFile(r, uploadDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Uploader != "alice" || f.UploadedAt.IsZero() {
+			t.Errorf("expected the uploader and time to be recorded, received %q at %v", f.Uploader, f.UploadedAt)
+		}
+
+		name := storageKey(uploadDir, f.NewFileName)
+		m, err := testTools.Metadata.Load(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.OriginalFileName != "Quarterly Report.txt" || m.Uploader != "alice" || m.SHA256 != f.SHA256 || m.FileSize != 7 {
+			t.Errorf("unexpected metadata %+v", m)
+		}
+
+		rr := httptest.NewRecorder()
+		testTools.DownLoadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), filepath.FromSlash(name), "")
+		if got := rr.Header().Get("content-disposition"); got != `attachment; filename="Quarterly Report.txt"` {
+			t.Errorf("expected the original file name to be restored, received %s", got)
+		}
+		if got := rr.Header().Get("content-type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("expected the detected content type, received %s", got)
+		}
+
+		testTools.AllOrNothing = true
+		r = newMultipartRequest(t,
+			testPart{field: "file", fileName: "a.txt", data: []byte("small")},
+			testPart{field: "file", fileName: "b.txt", data: []byte("larger than the limit of the field")},
+		)
+		testTools.FieldRules = map[string]FieldRule{"file": {MaxFileSize: 20}}
+		if _, err := testTools.UploadFile(r, uploadDir); err == nil {
+			t.Fatal("expected the second file to fail")
+		}
+		if store == nil {
+			entries, _ := os.ReadDir(uploadDir)
+			if len(entries) != 2 {
+				t.Errorf("expected only the first upload and its sidecar to remain, found %d files", len(entries))
+			}
+		}
+	}
+}
+
+func TestToolsDownloadStaticFileDisposition(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "stored.png")
+	if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	expected := map[string]string{
+		`x"; filename*=UTF-8''evil.html; a=".png`: `attachment; filename="x\"; filename*=UTF-8''evil.html; a=\".png"`,
+		`back\slash.txt`:  `attachment; filename="back\\slash.txt"`,
+		"résumé über.pdf": `attachment; filename="r_sum_ _ber.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%C3%BCber.pdf`,
+	}
+	for name, header := range expected {
+		rr := httptest.NewRecorder()
+		testTools.DownLoadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), p, name)
+		if got := rr.Header().Get("content-disposition"); got != header {
+			t.Errorf("%q: expected %s, received %s", name, header, got)
+		}
+
+		disposition, params, err := mime.ParseMediaType(rr.Header().Get("content-disposition"))
+		if err != nil {
+			t.Errorf("%q: unable to parse %s: %v", name, rr.Header().Get("content-disposition"), err)
+			continue
+		}
+		if disposition != "attachment" || len(params) != 1 || params["filename"] != name {
+			t.Errorf("%q: expected only the file name as a parameter, received %s %v", name, disposition, params)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package toolkit
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// uploadJob is a file of a request waiting to be stored.
+type uploadJob struct {
+	p uploadPart
+	// open returns the content of the file. It is not called if err is set.
+	open func() (io.ReadCloser, error)
+	// err is set if the file was rejected before its content was read.
+	err error
+}
+
+// uploadPool stores the files of one request. With Concurrency above one it stores them on that many
+// goroutines, otherwise it stores each file as it is submitted. Unless Concurrency is above one or KeepPartial
+// is set it stops at the first file that fails.
+type uploadPool struct {
+	t          *Tools
+	uploadDir  string
+	renameFile bool
+	state      *uploadState
+	// keepGoing is set when every file is tried even if some fail.
+	keepGoing bool
+
+	jobs chan indexedJob
+	wg   sync.WaitGroup
+
+	mu    sync.Mutex
+	files []*UploadedFile
+	errs  []*FileError
+}
+
+// indexedJob is an uploadJob along with its position in the request.
+type indexedJob struct {
+	uploadJob
+	index int
+}
+
+func (t *Tools) newUploadPool(uploadDir string, renameFile bool, state *uploadState) *uploadPool {
+	pool := &uploadPool{t: t, uploadDir: uploadDir, renameFile: renameFile, state: state, keepGoing: t.Concurrency > 1 || t.KeepPartial}
+	if t.Concurrency <= 1 {
+		return pool
+	}
+
+	pool.jobs = make(chan indexedJob)
+	for i := 0; i < t.Concurrency; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				f, err := pool.store(job.uploadJob)
+				pool.mu.Lock()
+				pool.files[job.index], pool.errs[job.index] = f, err
+				pool.mu.Unlock()
+			}
+		}()
+	}
+	return pool
+}
+
+// parallel reports whether files are stored on other goroutines, in which case their content must stay
+// readable after submit returns.
+func (pool *uploadPool) parallel() bool {
+	return pool.jobs != nil
+}
+
+// submit stores the file of job, or hands it to a free goroutine, blocking until there is one. When the pool
+// stops at the first failure, the error of the file is returned as it is.
+func (pool *uploadPool) submit(job uploadJob) error {
+	pool.mu.Lock()
+	index := len(pool.files)
+	pool.files, pool.errs = append(pool.files, nil), append(pool.errs, nil)
+	pool.mu.Unlock()
+
+	if pool.parallel() {
+		pool.jobs <- indexedJob{uploadJob: job, index: index}
+		return nil
+	}
+
+	f, err := pool.store(job)
+	pool.files[index], pool.errs[index] = f, err
+	if err != nil && !pool.keepGoing {
+		return err.Err
+	}
+	return nil
+}
+
+// wait waits for every submitted file to be stored and returns the files that were stored, in the order they
+// were submitted, along with a *MultiUploadError for the others.
+func (pool *uploadPool) wait() ([]*UploadedFile, error) {
+	if pool.parallel() {
+		close(pool.jobs)
+		pool.wg.Wait()
+	}
+
+	var files []*UploadedFile
+	var errs []*FileError
+	for i, f := range pool.files {
+		if pool.errs[i] != nil {
+			errs = append(errs, pool.errs[i])
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(errs) > 0 {
+		return files, &MultiUploadError{Files: errs}
+	}
+	return files, nil
+}
+
+// store stores the file of job.
+func (pool *uploadPool) store(job uploadJob) (*UploadedFile, *FileError) {
+	f, err := pool.storeJob(job)
+	if err != nil {
+		return nil, &FileError{FieldName: job.p.field, OriginalFileName: job.p.fileName, Err: err}
+	}
+	return f, nil
+}
+
+func (pool *uploadPool) storeJob(job uploadJob) (*UploadedFile, error) {
+	if job.err != nil {
+		return nil, job.err
+	}
+
+	rc, err := job.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	src := pool.state.track(rc, job.p)
+	f, err := pool.t.storeFile(src, job.p, pool.uploadDir, pool.renameFile, pool.state.uploader)
+	if err != nil {
+		return nil, err
+	}
+	src.done()
+	return f, nil
+}
+
+// spooledFile is a spooled part that is removed when it is closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (s spooledFile) Close() error {
+	discardSpool(s.File)
+	return nil
+}
+
+// abortUpload waits for the files already submitted to pool, rolls them back and returns err. The files
+// stored so far are kept unless AllOrNothing is set.
+func (t *Tools) abortUpload(result *UploadResult, pool *uploadPool, err error) (*UploadResult, error) {
+	files, _ := pool.wait()
+	result.Files = t.rollback(pool.uploadDir, files)
+	return result, err
+}
+
+// finishUpload waits for the files submitted to pool and adds them to result. If any file failed the other
+// files are rolled back as for any other error.
+func (t *Tools) finishUpload(result *UploadResult, pool *uploadPool) (*UploadResult, error) {
+	files, err := pool.wait()
+	if err != nil {
+		result.Files = t.rollback(pool.uploadDir, files)
+		return result, err
+	}
+	result.Files = files
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowScanner is a Scanner that takes a while over each file and records how many scans ran at once.
+type slowScanner struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (s *slowScanner) Scan(r io.Reader) (ScanResult, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.max {
+		s.max = s.running
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	_, err := io.Copy(io.Discard, r)
+
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	return ScanResult{}, err
+}
+
+func TestToolsUploadFileConcurrency(t *testing.T) {
+	var parts []testPart
+	for i := 0; i < 12; i++ {
+		parts = append(parts, testPart{field: "file", fileName: fmt.Sprintf("%02d.txt", i), data: []byte(strings.Repeat("x", 1000*i+1))})
+	}
+
+	for _, stream := range []bool{false, true} {
+		scanner := &slowScanner{}
+		testTools := Tools{StreamUploads: stream, Scanner: scanner, Concurrency: 4}
+
+		files, err := testTools.UploadFile(newMultipartRequest(t, parts...), t.TempDir())
+		if err != nil {
+			t.Fatalf("stream %v: unexpected error %v", stream, err)
+		}
+		if scanner.max < 2 || scanner.max > 4 {
+			t.Errorf("stream %v: expected between 2 and 4 files to be scanned at once, received %d", stream, scanner.max)
+		}
+		if len(files) != len(parts) {
+			t.Fatalf("stream %v: expected %d files, received %d", stream, len(parts), len(files))
+		}
+		for i, f := range files {
+			if f.OriginalFileName != parts[i].fileName || f.FileSize != int64(len(parts[i].data)) {
+				t.Errorf("stream %v: expected %s in position %d, received %s", stream, parts[i].fileName, i, f.OriginalFileName)
+			}
+		}
+	}
+}
+
+func TestToolsUploadFileConcurrencyErrors(t *testing.T) {
+	parts := []testPart{
+		{field: "file", fileName: "a.png", data: testPNG(t, 2, 2)},
+		{field: "file", fileName: "b.txt", data: []byte("not an image")},
+		{field: "file", fileName: "c.png", data: testPNG(t, 2, 2)},
+		{field: "file", fileName: "d.png", data: testPNG(t, 64, 64)},
+		{field: "file", fileName: "e.png", data: testPNG(t, 2, 2)},
+	}
+
+	for _, stream := range []bool{false, true} {
+		testTools := Tools{StreamUploads: stream, Concurrency: 3, AllowedTypes: []string{"image/png"}, FieldRules: map[string]FieldRule{"file": {MaxFileSize: 4096}}}
+
+		files, err := testTools.UploadFile(newMultipartRequest(t, parts...), t.TempDir())
+		if !errors.Is(err, ErrFileTypeNotAllowed) || !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("stream %v: expected both failures to be reported, received %v", stream, err)
+		}
+		if err != nil && (!strings.Contains(err.Error(), "b.txt") || !strings.Contains(err.Error(), "d.png")) {
+			t.Errorf("stream %v: expected the errors to name the files, received %v", stream, err)
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.OriginalFileName)
+		}
+		if strings.Join(names, ",") != "a.png,c.png,e.png" {
+			t.Errorf("stream %v: expected the other files to be kept in order, received %v", stream, names)
+		}
+
+		testTools.AllOrNothing = true
+		if files, _ := testTools.UploadFile(newMultipartRequest(t, parts...), t.TempDir()); files != nil {
+			t.Errorf("stream %v: expected AllOrNothing to remove every file, received %d", stream, len(files))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// defaultPasswordLength is the length of passwords made by a PasswordPolicy that does not set Length.
+	defaultPasswordLength = 16
+	// defaultSymbols are the symbols used by a PasswordPolicy that does not set Symbols.
+	defaultSymbols = "!#$%&*+-=?@^_~"
+	// ambiguousChars are left out of passwords by ExcludeAmbiguous, as they are easily mistaken for each other.
+	ambiguousChars = "0Oo1Il|"
+	// defaultPassphraseWords is the number of words in a passphrase made by a PassphrasePolicy that does not
+	// set Words. Six words of the EFF list give about 77 bits of randomness.
+	defaultPassphraseWords = 6
+)
+
+// ErrImpossiblePolicy is returned when a PasswordPolicy cannot be met, for example because its minimums add up
+// to more than its Length.
+var ErrImpossiblePolicy = errors.New("the password policy cannot be satisfied")
+
+// ErrWeakPassword is returned by PasswordPolicy.Check when a password does not meet the policy.
+var ErrWeakPassword = errors.New("the password does not meet the policy")
+
+// effLargeWordList is the EFF large word list for diceware passphrases, by the Electronic Frontier Foundation
+// under the Creative Commons Attribution 3.0 licence. Each line is the five dice rolls of a word, a tab and
+// the word. See https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases.
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordList string
+
+var (
+	passphraseWordsOnce sync.Once
+	passphraseWords     []string
+)
+
+// PasswordPolicy describes the passwords made by Generate and accepted by Check. The zero value makes
+// passwords of 16 letters, digits and symbols.
+type PasswordPolicy struct {
+	// Length is the number of characters in the password. The default is 16.
+	Length int
+	// MinLower, MinUpper, MinDigits and MinSymbols are the least number of lower case letters, upper case
+	// letters, digits and symbols a password must have.
+	MinLower   int
+	MinUpper   int
+	MinDigits  int
+	MinSymbols int
+	// Symbols are the symbols passwords may contain. The default is "!#$%&*+-=?@^_~". They must be printable
+	// ASCII characters other than letters, digits and space, each given once.
+	Symbols string
+	// NoSymbols leaves symbols out of generated passwords, for systems that do not accept them.
+	NoSymbols bool
+	// ExcludeAmbiguous leaves out characters that are easily mistaken for each other, such as 0 and O or 1, l
+	// and I, which makes passwords easier to read out and type.
+	ExcludeAmbiguous bool
+}
+
+// Generate returns a new password that meets the policy. Characters are picked from crypto/rand with every
+// allowed character equally likely, apart from those needed to meet the minimums, and then shuffled.
+func (p PasswordPolicy) Generate() (string, error) {
+	classes, mins, err := p.classes()
+	if err != nil {
+		return "", err
+	}
+	n := p.length()
+	total := 0
+	for _, m := range mins {
+		total += m
+	}
+	if total > n {
+		return "", fmt.Errorf("%w: the minimums need %d characters but Length is %d", ErrImpossiblePolicy, total, n)
+	}
+
+	password := make([]byte, 0, n)
+	for i, class := range classes {
+		s, err := pick(class, mins[i])
+		if err != nil {
+			return "", err
+		}
+		password = append(password, s...)
+	}
+	s, err := pick(strings.Join(classes, ""), n-total)
+	if err != nil {
+		return "", err
+	}
+	password = append(password, s...)
+
+	// Fisher-Yates, so that the characters picked for the minimums can be anywhere
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+// Check returns an error wrapping ErrWeakPassword if password is shorter than Length or has fewer characters of
+// a class than the policy requires. Characters outside the policy, such as other symbols, are allowed. An error
+// wrapping ErrImpossiblePolicy is returned if Symbols is not valid.
+func (p PasswordPolicy) Check(password string) error {
+	if err := p.checkSymbols(); err != nil {
+		return err
+	}
+
+	var lower, upper, digits, symbols, length int
+	for _, r := range password {
+		length++
+		switch {
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsDigit(r):
+			digits++
+		case strings.ContainsRune(p.symbols(), r):
+			symbols++
+		}
+	}
+
+	var problems []string
+	if length < p.length() {
+		problems = append(problems, fmt.Sprintf("at least %d characters", p.length()))
+	}
+	for _, c := range []struct {
+		have, want int
+		name       string
+	}{
+		{lower, p.MinLower, "lower case letters"},
+		{upper, p.MinUpper, "upper case letters"},
+		{digits, p.MinDigits, "digits"},
+		{symbols, p.MinSymbols, "symbols"},
+	} {
+		if c.have < c.want {
+			problems = append(problems, fmt.Sprintf("at least %d %s", c.want, c.name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: it needs %s", ErrWeakPassword, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// classes returns the characters of each class used to generate passwords, with the minimum of each.
+func (p PasswordPolicy) classes() ([]string, []int, error) {
+	classes := []string{"abcdefghijklmnopqrstuvwxyz", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "0123456789"}
+	mins := []int{p.MinLower, p.MinUpper, p.MinDigits}
+	if p.NoSymbols {
+		if p.MinSymbols > 0 {
+			return nil, nil, fmt.Errorf("%w: MinSymbols is set but NoSymbols leaves symbols out", ErrImpossiblePolicy)
+		}
+	} else {
+		if err := p.checkSymbols(); err != nil {
+			return nil, nil, err
+		}
+		classes = append(classes, p.symbols())
+		mins = append(mins, p.MinSymbols)
+	}
+
+	for i, class := range classes {
+		if mins[i] < 0 {
+			return nil, nil, fmt.Errorf("%w: a minimum is negative", ErrImpossiblePolicy)
+		}
+		if p.ExcludeAmbiguous {
+			class = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(ambiguousChars, r) {
+					return -1
+				}
+				return r
+			}, class)
+		}
+		classes[i] = class
+	}
+	return classes, mins, nil
+}
+
+// checkSymbols returns an error wrapping ErrImpossiblePolicy unless Symbols is made of printable ASCII
+// characters that are not letters, digits or space, with none repeated. A repeated symbol would be picked more
+// often than the others, and a letter or digit would be counted twice by the minimums.
+func (p PasswordPolicy) checkSymbols() error {
+	if p.NoSymbols {
+		return nil
+	}
+	seen := make(map[rune]bool)
+	for _, r := range p.symbols() {
+		if r > unicode.MaxASCII || !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			return fmt.Errorf("%w: Symbols may only hold printable ASCII symbols, not %q", ErrImpossiblePolicy, r)
+		}
+		if seen[r] {
+			return fmt.Errorf("%w: %q is repeated in Symbols", ErrImpossiblePolicy, r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+func (p PasswordPolicy) length() int {
+	if p.Length <= 0 {
+		return defaultPasswordLength
+	}
+	return p.Length
+}
+
+func (p PasswordPolicy) symbols() string {
+	if p.Symbols == "" {
+		return defaultSymbols
+	}
+	return p.Symbols
+}
+
+// pick returns n characters picked at random from the ASCII characters of chars.
+func pick(chars string, n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if chars == "" {
+		return nil, fmt.Errorf("%w: a required class has no characters", ErrImpossiblePolicy)
+	}
+
+	idx := make([]int, n)
+	if err := randomIndexes(idx, len(chars)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	for i, x := range idx {
+		b[i] = chars[x]
+	}
+	return b, nil
+}
+
+// PassphrasePolicy describes diceware style passphrases made of words from the EFF large word list, such as
+// "unbiased-crumpled-dwelling-perky-reptile-sample". The zero value makes passphrases of six words joined by
+// hyphens.
+type PassphrasePolicy struct {
+	// Words is the number of words. The default is 6.
+	Words int
+	// Separator joins the words. The default is "-".
+	Separator string
+	// Capitalize makes the first letter of each word upper case.
+	Capitalize bool
+	// AddDigit appends a random digit to one of the words, for systems that require a digit.
+	AddDigit bool
+}
+
+// Generate returns a new passphrase. Each word is picked from crypto/rand with all 7776 words of the list
+// equally likely.
+func (p PassphrasePolicy) Generate() (string, error) {
+	list := passphraseWordList()
+	n := p.Words
+	if n <= 0 {
+		n = defaultPassphraseWords
+	}
+
+	words := make([]string, n)
+	for i := range words {
+		x, err := rand.Int(rand.Reader, big.NewInt(int64(len(list))))
+		if err != nil {
+			return "", err
+		}
+		words[i] = list[x.Int64()]
+		if p.Capitalize {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+
+	if p.AddDigit {
+		idx := make([]int, 1)
+		if err := randomIndexes(idx, 10); err != nil {
+			return "", err
+		}
+		i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+		if err != nil {
+			return "", err
+		}
+		words[i.Int64()] += string(rune('0' + idx[0]))
+	}
+
+	separator := p.Separator
+	if separator == "" {
+		separator = "-"
+	}
+	return strings.Join(words, separator), nil
+}
+
+// passphraseWordList returns the words of effLargeWordList, parsed the first time it is called.
+func passphraseWordList() []string {
+	passphraseWordsOnce.Do(func() {
+		lines := strings.Split(strings.TrimSpace(effLargeWordList), "\n")
+		passphraseWords = make([]string, 0, len(lines))
+		for _, line := range lines {
+			_, word, _ := strings.Cut(strings.TrimSpace(line), "\t")
+			passphraseWords = append(passphraseWords, word)
+		}
+	})
+	return passphraseWords
+}
@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policyTests := []struct {
+		name      string
+		policy    PasswordPolicy
+		pattern   string
+		errExpect bool
+	}{
+		{name: "default", policy: PasswordPolicy{}, pattern: `^[a-zA-Z0-9!#$%&*+\-=?@^_~]{16}$`},
+		{name: "minimums", policy: PasswordPolicy{Length: 8, MinLower: 2, MinUpper: 2, MinDigits: 2, MinSymbols: 2}, pattern: `^.{8}$`},
+		{name: "no symbols", policy: PasswordPolicy{Length: 20, NoSymbols: true, MinDigits: 3}, pattern: `^[a-zA-Z0-9]{20}$`},
+		{name: "own symbols", policy: PasswordPolicy{Length: 12, Symbols: "!.", MinSymbols: 4}, pattern: `^[a-zA-Z0-9!.]{12}$`},
+		{name: "unambiguous", policy: PasswordPolicy{Length: 200, ExcludeAmbiguous: true}, pattern: `^[^0Oo1Il|]{200}$`},
+		{name: "too short for minimums", policy: PasswordPolicy{Length: 4, MinDigits: 3, MinUpper: 2}, errExpect: true},
+		{name: "symbols left out", policy: PasswordPolicy{NoSymbols: true, MinSymbols: 1}, errExpect: true},
+		{name: "negative minimum", policy: PasswordPolicy{MinLower: -1}, errExpect: true},
+		{name: "only ambiguous symbols", policy: PasswordPolicy{Symbols: "|", MinSymbols: 1, ExcludeAmbiguous: true}, errExpect: true},
+		{name: "repeated symbols", policy: PasswordPolicy{Symbols: "!!!?"}, errExpect: true},
+		{name: "letters in symbols", policy: PasswordPolicy{Symbols: "!a"}, errExpect: true},
+		{name: "digits in symbols", policy: PasswordPolicy{Symbols: "#7"}, errExpect: true},
+		{name: "space in symbols", policy: PasswordPolicy{Symbols: "! "}, errExpect: true},
+		{name: "non ASCII symbols", policy: PasswordPolicy{Symbols: "!€"}, errExpect: true},
+	}
+
+	for _, e := range policyTests {
+		for i := 0; i < 50; i++ {
+			s, err := e.policy.Generate()
+			if e.errExpect {
+				if !errors.Is(err, ErrImpossiblePolicy) {
+					t.Errorf("%s: expected ErrImpossiblePolicy, received %v", e.name, err)
+				}
+				break
+			}
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+				break
+			}
+			if !regexp.MustCompile(e.pattern).MatchString(s) {
+				t.Errorf("%s: %q does not match %s", e.name, s, e.pattern)
+				break
+			}
+			if err := e.policy.Check(s); err != nil {
+				t.Errorf("%s: %q does not meet its own policy: %v", e.name, s, err)
+				break
+			}
+		}
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{Length: 10, MinUpper: 1, MinDigits: 2, MinSymbols: 1}
+
+	if err := policy.Check("Correct-horse-42"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	err := policy.Check("password1")
+	if !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("expected ErrWeakPassword, received %v", err)
+	}
+	for _, want := range []string{"10 characters", "1 upper case", "2 digits", "1 symbols"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q to mention %q", err, want)
+		}
+	}
+
+	if err := (PasswordPolicy{Symbols: "a1"}).Check("abcdefghijklmnop1"); !errors.Is(err, ErrImpossiblePolicy) {
+		t.Errorf("expected ErrImpossiblePolicy for letters and digits in Symbols, received %v", err)
+	}
+}
+
+func TestPassphrasePolicy(t *testing.T) {
+	list := passphraseWordList()
+	if len(list) != 7776 {
+		t.Fatalf("expected 7776 words, found %d", len(list))
+	}
+	words := make(map[string]bool, len(list))
+	for _, w := range list {
+		words[w] = true
+	}
+
+	s, err := PassphrasePolicy{}.Generate()
+	if err != nil || strings.Count(s, "-") < 5 {
+		t.Fatalf("expected six words joined by hyphens, received %q (%v)", s, err)
+	}
+
+	// a few words, such as t-shirt, have hyphens of their own
+	s, err = PassphrasePolicy{Separator: " "}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(s, " ")
+	if len(parts) != 6 {
+		t.Fatalf("expected 6 words, received %q", s)
+	}
+	for _, p := range parts {
+		if !words[p] {
+			t.Errorf("%q is not in the word list", p)
+		}
+	}
+
+	s, err = PassphrasePolicy{Words: 4, Separator: " ", Capitalize: true, AddDigit: true}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[A-Z][a-z-]*[0-9]?( [A-Z][a-z-]*[0-9]?){3}$`).MatchString(s) || !regexp.MustCompile(`[0-9]`).MatchString(s) {
+		t.Errorf("unexpected passphrase %q", s)
+	}
+}
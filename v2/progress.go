@@ -0,0 +1,105 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// UploadProgress is reported to OnProgress as the files of a request are stored.
+type UploadProgress struct {
+	FieldName string
+	FileName  string
+	// FileBytes is the number of bytes of the file stored so far.
+	FileBytes int64
+	// TotalBytes is the number of bytes of all the files of the request stored so far.
+	TotalBytes int64
+	// ContentLength is the size of the request body, or -1 if it is not known. It includes the form fields and
+	// multipart headers, so it is a little larger than TotalBytes at the end.
+	ContentLength int64
+	// Done is set in the last report for a file, once it has been stored.
+	Done bool
+}
+
+// uploadState is the state shared by the files of one UploadForm call.
+type uploadState struct {
+	ctx context.Context
+	// counts holds the number of files seen so far in each form field.
+	counts map[string]int
+	// valueBytes is the number of bytes of form values that may still be read.
+	valueBytes    int64
+	uploader      string
+	onProgress    func(UploadProgress)
+	contentLength int64
+	// total is the number of bytes of files stored so far. It is updated atomically.
+	total int64
+}
+
+func (t *Tools) newUploadState(r *http.Request) *uploadState {
+	var uploader string
+	if t.Uploader != nil {
+		uploader = t.Uploader(r)
+	}
+
+	return &uploadState{
+		ctx:           r.Context(),
+		counts:        make(map[string]int),
+		valueBytes:    maxFormValueSize,
+		onProgress:    t.OnProgress,
+		contentLength: r.ContentLength,
+		uploader:      uploader,
+	}
+}
+
+// track returns src wrapped so that reading it fails once the request is cancelled, and reports the progress
+// of p to OnProgress.
+func (s *uploadState) track(src io.Reader, p uploadPart) *progressReader {
+	return &progressReader{s: s, r: src, progress: UploadProgress{FieldName: p.field, FileName: p.fileName, ContentLength: s.contentLength}}
+}
+
+// progressReader is returned by track.
+type progressReader struct {
+	s        *uploadState
+	r        io.Reader
+	progress UploadProgress
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	if err := pr.s.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.progress.FileBytes += int64(n)
+		pr.progress.TotalBytes = atomic.AddInt64(&pr.s.total, int64(n))
+		pr.report()
+	}
+	return n, err
+}
+
+// done reports that the file has been stored.
+func (pr *progressReader) done() {
+	pr.progress.Done = true
+	pr.report()
+}
+
+func (pr *progressReader) report() {
+	if pr.s.onProgress != nil {
+		pr.s.onProgress(pr.progress)
+	}
+}
+
+// contextReader reads from r until ctx is done, after which it fails with the error of ctx.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,56 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestToolsUploadFileProgress(t *testing.T) {
+	a, b := bytes.Repeat([]byte("a"), 100_000), bytes.Repeat([]byte("b"), 50_000)
+
+	for _, stream := range []bool{false, true} {
+		var reports []UploadProgress
+		testTools := Tools{StreamUploads: stream, OnProgress: func(p UploadProgress) { reports = append(reports, p) }}
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: a}, testPart{field: "file", fileName: "b.txt", data: b})
+		if _, err := testTools.UploadFile(r, t.TempDir()); err != nil {
+			t.Fatalf("stream %v: unexpected error %v", stream, err)
+		}
+
+		var done []UploadProgress
+		for i, p := range reports {
+			if i > 0 && p.TotalBytes < reports[i-1].TotalBytes {
+				t.Errorf("stream %v: TotalBytes went backwards", stream)
+			}
+			if p.Done {
+				done = append(done, p)
+			}
+		}
+		if len(done) != 2 || done[0].FileBytes != int64(len(a)) || done[1].FileBytes != int64(len(b)) {
+			t.Fatalf("stream %v: unexpected final reports %+v", stream, done)
+		}
+		if done[1].TotalBytes != int64(len(a)+len(b)) || done[1].ContentLength != r.ContentLength {
+			t.Errorf("stream %v: unexpected totals %+v", stream, done[1])
+		}
+	}
+}
+
+func TestToolsUploadFileCancel(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		uploadDir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		testTools := Tools{StreamUploads: stream, OnProgress: func(UploadProgress) { cancel() }}
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: bytes.Repeat([]byte("a"), 1<<20)})
+		_, err := testTools.UploadFile(r.WithContext(ctx), uploadDir)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("stream %v: expected context.Canceled, received %v", stream, err)
+		}
+		if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+			t.Errorf("stream %v: expected the partial file to be removed, found %d files", stream, len(entries))
+		}
+	}
+}
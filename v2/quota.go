@@ -0,0 +1,193 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrQuotaExceeded is matched by a *QuotaError with errors.Is.
+var ErrQuotaExceeded = errors.New("the storage quota has been exceeded")
+
+// QuotaError is returned when storing a file would take its owner over their quota.
+type QuotaError struct {
+	Owner string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("the storage quota of %q has been exceeded", e.Owner)
+}
+
+// Is makes a *QuotaError match ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// StatusCode is the status ErrorJSON sends for the error: 413 Request Entity Too Large.
+func (e *QuotaError) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// Quota keeps track of how many bytes each owner stores. Owners are identified by the Uploader of Tools.
+type Quota interface {
+	// Remaining returns how many more bytes owner may store. It may be negative if the limit was lowered.
+	Remaining(owner string) (int64, error)
+	// Add records that owner stores n more bytes, or frees -n bytes if n is negative. It fails with a
+	// *QuotaError and records nothing if a positive n would take owner over their quota.
+	Add(owner string, n int64) error
+}
+
+// quotaUsage is the accounting shared by MemoryQuota and FileQuota.
+type quotaUsage struct {
+	limit  int64
+	limits map[string]int64
+}
+
+func (q quotaUsage) limitOf(owner string) int64 {
+	if l, ok := q.limits[owner]; ok {
+		return l
+	}
+	return q.limit
+}
+
+// add adds n to the usage of owner in used.
+func (q quotaUsage) add(used map[string]int64, owner string, n int64) error {
+	limit := q.limitOf(owner)
+	if n > 0 && used[owner]+n > limit {
+		return &QuotaError{Owner: owner}
+	}
+
+	used[owner] += n
+	if used[owner] <= 0 {
+		delete(used, owner)
+	}
+	return nil
+}
+
+// MemoryQuota is a Quota kept in memory. Every owner may store Limit bytes unless Limits gives them another
+// limit. The zero value allows nothing to be stored.
+type MemoryQuota struct {
+	Limit  int64
+	Limits map[string]int64
+
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+// Remaining returns the limit of owner less what they store.
+func (q *MemoryQuota) Remaining(owner string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return quotaUsage{q.Limit, q.Limits}.limitOf(owner) - q.used[owner], nil
+}
+
+// Add records that owner stores n more bytes.
+func (q *MemoryQuota) Add(owner string, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used == nil {
+		q.used = make(map[string]int64)
+	}
+	return quotaUsage{q.Limit, q.Limits}.add(q.used, owner, n)
+}
+
+// FileQuota is a Quota that saves what each owner stores as JSON in the file Path, so that usage survives
+// restarts. It is safe to use from many goroutines but not from several processes at once.
+type FileQuota struct {
+	Path   string
+	Limit  int64
+	Limits map[string]int64
+
+	mu sync.Mutex
+}
+
+// Remaining returns the limit of owner less what they store.
+func (q *FileQuota) Remaining(owner string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	used, err := q.load()
+	if err != nil {
+		return 0, err
+	}
+	return quotaUsage{q.Limit, q.Limits}.limitOf(owner) - used[owner], nil
+}
+
+// Add records that owner stores n more bytes and saves the result.
+func (q *FileQuota) Add(owner string, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	used, err := q.load()
+	if err != nil {
+		return err
+	}
+	if err := (quotaUsage{q.Limit, q.Limits}).add(used, owner, n); err != nil {
+		return err
+	}
+	return q.save(used)
+}
+
+// load reads the usage saved in Path. A missing file means nothing is stored.
+func (q *FileQuota) load() (map[string]int64, error) {
+	used := make(map[string]int64)
+	b, err := os.ReadFile(q.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return used, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return used, json.Unmarshal(b, &used)
+}
+
+// save writes used to Path, replacing the file atomically.
+func (q *FileQuota) save(used map[string]int64) error {
+	b, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.Path), ".quota-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = renameFile(tmp.Name(), q.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// quotaReader returns src limited to the quota remaining for owner, so that a file that would exceed it fails
+// with a *QuotaError as soon as it does. It fails straight away if nothing remains.
+func (t *Tools) quotaReader(src io.Reader, owner string) (io.Reader, error) {
+	if t.Quota == nil {
+		return src, nil
+	}
+
+	remaining, err := t.Quota.Remaining(owner)
+	if err != nil {
+		return nil, err
+	}
+	quotaErr := &QuotaError{Owner: owner}
+	if remaining <= 0 {
+		return nil, quotaErr
+	}
+	return &maxBytesReader{r: src, n: remaining, err: quotaErr}, nil
+}
@@ -0,0 +1,89 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolsUploadFileQuota(t *testing.T) {
+	data := bytes.Repeat([]byte("q"), 60_000)
+
+	for _, e := range []struct {
+		stream      bool
+		concurrency int
+	}{{false, 0}, {true, 0}, {true, 2}} {
+		stream := e.stream
+		uploadDir := t.TempDir()
+		quota := &MemoryQuota{Limit: 100_000, Limits: map[string]int64{"bob": 10}}
+		testTools := Tools{StreamUploads: stream, Concurrency: e.concurrency, Quota: quota, Uploader: func(r *http.Request) string { return r.Header.Get("x-user") }}
+
+		upload := func(user string, parts ...testPart) error {
+			r := newMultipartRequest(t, parts...)
+			r.Header.Set("x-user", user)
+			_, err := testTools.UploadFile(r, uploadDir)
+			return err
+		}
+
+		if err := upload("alice", testPart{field: "file", fileName: "a.txt", data: data}); err != nil {
+			t.Fatalf("stream %v: unexpected error %v", stream, err)
+		}
+		err := upload("alice", testPart{field: "file", fileName: "b.txt", data: data})
+		var quotaErr *QuotaError
+		if !errors.As(err, &quotaErr) || quotaErr.Owner != "alice" || !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("stream %v: expected a *QuotaError for alice, received %v", stream, err)
+		}
+		if remaining, _ := quota.Remaining("alice"); remaining != 40_000 {
+			t.Errorf("stream %v: expected 40000 bytes to remain, received %d", stream, remaining)
+		}
+		if entries, _ := os.ReadDir(uploadDir); len(entries) != 1 {
+			t.Errorf("stream %v: expected only the first file to be stored, found %d files", stream, len(entries))
+		}
+
+		if err := upload("bob", testPart{field: "file", fileName: "c.txt", data: []byte("more than ten bytes")}); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("stream %v: expected bob's own limit to apply, received %v", stream, err)
+		}
+
+		testTools.AllOrNothing = true
+		err = upload("carol", testPart{field: "file", fileName: "d.txt", data: data}, testPart{field: "file", fileName: "e.txt", data: data})
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("stream %v: expected the second file to exceed the quota, received %v", stream, err)
+		}
+		if remaining, _ := quota.Remaining("carol"); remaining != 100_000 {
+			t.Errorf("stream %v: expected the rolled back file to be freed, %d bytes remain", stream, remaining)
+		}
+
+		rr := httptest.NewRecorder()
+		testTools.ErrorJSON(rr, err)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("stream %v: expected ErrorJSON to send %d, received %d", stream, http.StatusRequestEntityTooLarge, rr.Code)
+		}
+	}
+}
+
+func TestFileQuota(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "quota.json")
+
+	q := &FileQuota{Path: p, Limit: 100}
+	if err := q.Add("alice", 70); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Add("alice", 40); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, received %v", err)
+	}
+
+	q = &FileQuota{Path: p, Limit: 100}
+	if remaining, err := q.Remaining("alice"); err != nil || remaining != 30 {
+		t.Errorf("expected the usage to be saved, received %d remaining (%v)", remaining, err)
+	}
+	if err := q.Add("alice", -70); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := q.Remaining("alice"); remaining != 100 {
+		t.Errorf("expected the space to be freed, received %d remaining", remaining)
+	}
+}
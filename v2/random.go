@@ -0,0 +1,182 @@
+package toolkit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Alphabet is the set of characters a TokenGenerator picks from. Each character must appear only once.
+type Alphabet string
+
+const (
+	// AlphabetHex is lowercase hexadecimal.
+	AlphabetHex Alphabet = "0123456789abcdef"
+	// AlphabetCrockford is Crockford's base32, which leaves out I, L, O and U so that codes can be read aloud
+	// and typed without confusing similar characters.
+	AlphabetCrockford Alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// AlphabetBase58 is the Bitcoin base58 alphabet, which leaves out 0, O, I and l.
+	AlphabetBase58 Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	// AlphabetURLSafe is the URL and filename safe base64 alphabet of RFC 4648.
+	AlphabetURLSafe Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	// AlphabetNumeric is the decimal digits.
+	AlphabetNumeric Alphabet = "0123456789"
+	// AlphabetFileName is lowercase letters and digits, which are safe in URLs and cannot collide on case
+	// insensitive file systems.
+	AlphabetFileName Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+// ErrInvalidAlphabet is returned by TokenGenerator when its alphabet has fewer than 2 or more than 256
+// characters, or repeats a character.
+var ErrInvalidAlphabet = errors.New("the alphabet must have from 2 to 256 distinct characters")
+
+// TokenGenerator makes random tokens such as invite codes, API keys and file names. The zero value makes
+// tokens of 22 URL safe characters.
+type TokenGenerator struct {
+	// Alphabet is the characters to pick from. The default is AlphabetURLSafe.
+	Alphabet Alphabet
+	// Length is the number of random characters. The default is enough for 128 bits of randomness.
+	Length int
+	// Prefix is prepended to every token, such as "sk_live_" for API keys. It does not count towards Length.
+	Prefix string
+	// GroupSize splits the random characters into groups of this size joined by Separator, such as
+	// "7KQM-2XHD". The default of 0 does not split them.
+	GroupSize int
+	// Separator joins the groups. The default is "-".
+	Separator string
+}
+
+// Generate returns a new token.
+func (g TokenGenerator) Generate() (string, error) {
+	alphabet := []rune(string(g.alphabet()))
+	if err := checkAlphabet(alphabet); err != nil {
+		return "", err
+	}
+	n := g.Length
+	if n <= 0 {
+		n = int(math.Ceil(128 / math.Log2(float64(len(alphabet)))))
+	}
+
+	idx := make([]int, n)
+	if err := randomIndexes(idx, len(alphabet)); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(g.Prefix)
+	for i, x := range idx {
+		if g.GroupSize > 0 && i > 0 && i%g.GroupSize == 0 {
+			b.WriteString(g.separator())
+		}
+		b.WriteRune(alphabet[x])
+	}
+	return b.String(), nil
+}
+
+// Bits returns how many bits of randomness each token holds, not counting Prefix or separators.
+func (g TokenGenerator) Bits() float64 {
+	size := utf8.RuneCountInString(string(g.alphabet()))
+	if size < 2 {
+		return 0
+	}
+	n := g.Length
+	if n <= 0 {
+		n = int(math.Ceil(128 / math.Log2(float64(size))))
+	}
+	return float64(n) * math.Log2(float64(size))
+}
+
+func (g TokenGenerator) alphabet() Alphabet {
+	if g.Alphabet == "" {
+		return AlphabetURLSafe
+	}
+	return g.Alphabet
+}
+
+func (g TokenGenerator) separator() string {
+	if g.Separator == "" {
+		return "-"
+	}
+	return g.Separator
+}
+
+// checkAlphabet returns ErrInvalidAlphabet unless alphabet can be used without bias.
+func checkAlphabet(alphabet []rune) error {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return ErrInvalidAlphabet
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, r := range alphabet {
+		if seen[r] || r == utf8.RuneError {
+			return fmt.Errorf("%w: %q is repeated or invalid", ErrInvalidAlphabet, r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// InviteCode returns a code of 8 Crockford base32 characters in two groups, such as "7KQM-2XHD", which is
+// easy to read out and type.
+func InviteCode() (string, error) {
+	return TokenGenerator{Alphabet: AlphabetCrockford, Length: 8, GroupSize: 4}.Generate()
+}
+
+// APIKey returns prefix followed by 32 base58 characters, about 187 bits of randomness. A prefix such as
+// "sk_live_" makes keys easy to recognise, for example by secret scanners.
+func APIKey(prefix string) (string, error) {
+	return TokenGenerator{Alphabet: AlphabetBase58, Length: 32, Prefix: prefix}.Generate()
+}
+
+// RandomFileName returns 25 lowercase letters and digits followed by ext, which should include the dot.
+func RandomFileName(ext string) (string, error) {
+	name, err := TokenGenerator{Alphabet: AlphabetFileName, Length: 25}.Generate()
+	if err != nil {
+		return "", err
+	}
+	return name + ext, nil
+}
+
+// randomReaders holds buffered readers of crypto/rand, so that random strings do not each need several reads
+// from the operating system.
+var randomReaders = sync.Pool{
+	New: func() any { return bufio.NewReaderSize(rand.Reader, 256) },
+}
+
+// randomIndexes fills idx with numbers from 0 to size-1, each equally likely. Random bytes are masked to the
+// fewest bits that can hold size-1 and rejected if they are too large, which avoids the bias of taking them
+// modulo size. size must be from 1 to 256.
+func randomIndexes(idx []int, size int) error {
+	if size < 1 || size > 256 {
+		return errors.New("the alphabet must have from 1 to 256 characters")
+	}
+	mask := byte(1<<bits.Len(uint(size-1)) - 1)
+
+	r := randomReaders.Get().(*bufio.Reader)
+	defer randomReaders.Put(r)
+
+	// a masked byte is accepted with a probability above one half, so twice as many bytes are usually enough
+	buf := make([]byte, 2*len(idx)+8)
+	for i := 0; i < len(idx); {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		for _, b := range buf {
+			if b &= mask; int(b) < size {
+				idx[i] = int(b)
+				i++
+				if i == len(idx) {
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
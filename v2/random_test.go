@@ -0,0 +1,153 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// chiSquare returns the chi-square statistic of counts against a uniform distribution.
+func chiSquare(counts []int, total int) float64 {
+	expected := float64(total) / float64(len(counts))
+	var x float64
+	for _, c := range counts {
+		d := float64(c) - expected
+		x += d * d / expected
+	}
+	return x
+}
+
+func TestRandomStringDistribution(t *testing.T) {
+	var testTools Tools
+
+	const n = 64 * 2000
+	s, err := testTools.RandomString(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make([]int, len(randomStringSource))
+	for _, c := range s {
+		i := strings.IndexRune(randomStringSource, c)
+		if i < 0 {
+			t.Fatalf("unexpected character %q", c)
+		}
+		counts[i]++
+	}
+	// 63 degrees of freedom: a uniform source exceeds 130 about once in a million runs
+	if x := chiSquare(counts, n); x > 130 {
+		t.Errorf("characters are not uniformly distributed, chi-square %.1f", x)
+	}
+}
+
+func TestRandomIndexes(t *testing.T) {
+	// 10 is not a power of two, so a modulo would favour the low indexes
+	idx := make([]int, 10*5000)
+	if err := randomIndexes(idx, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make([]int, 10)
+	for _, i := range idx {
+		if i < 0 || i >= 10 {
+			t.Fatalf("index %d out of range", i)
+		}
+		counts[i]++
+	}
+	// 9 degrees of freedom: a uniform source exceeds 40 about once in a hundred thousand runs
+	if x := chiSquare(counts, len(idx)); x > 40 {
+		t.Errorf("indexes are not uniformly distributed, chi-square %.1f", x)
+	}
+
+	for _, size := range []int{0, 257} {
+		if err := randomIndexes(idx, size); err == nil {
+			t.Errorf("expected an error for an alphabet of %d characters", size)
+		}
+	}
+	if err := randomIndexes(idx[:5], 1); err != nil || idx[0] != 0 {
+		t.Errorf("expected an alphabet of one character to always give 0, received %d (%v)", idx[0], err)
+	}
+}
+
+func TestTokenGenerator(t *testing.T) {
+	tokenTests := []struct {
+		name      string
+		g         TokenGenerator
+		pattern   string
+		errExpect bool
+	}{
+		{name: "default", g: TokenGenerator{}, pattern: `^[A-Za-z0-9_-]{22}$`},
+		{name: "hex", g: TokenGenerator{Alphabet: AlphabetHex}, pattern: `^[0-9a-f]{32}$`},
+		{name: "crockford", g: TokenGenerator{Alphabet: AlphabetCrockford, Length: 12, GroupSize: 4}, pattern: `^[0-9A-HJKMNP-TV-Z]{4}-[0-9A-HJKMNP-TV-Z]{4}-[0-9A-HJKMNP-TV-Z]{4}$`},
+		{name: "base58", g: TokenGenerator{Alphabet: AlphabetBase58, Length: 40, Prefix: "pk_"}, pattern: `^pk_[1-9A-HJ-NP-Za-km-z]{40}$`},
+		{name: "numeric", g: TokenGenerator{Alphabet: AlphabetNumeric, Length: 6, GroupSize: 3, Separator: " "}, pattern: `^[0-9]{3} [0-9]{3}$`},
+		{name: "custom", g: TokenGenerator{Alphabet: "αβγδ", Length: 5}, pattern: `^[αβγδ]{5}$`},
+		{name: "one character", g: TokenGenerator{Alphabet: "a"}, errExpect: true},
+		{name: "repeated character", g: TokenGenerator{Alphabet: "abca"}, errExpect: true},
+	}
+
+	for _, e := range tokenTests {
+		s, err := e.g.Generate()
+		if e.errExpect {
+			if !errors.Is(err, ErrInvalidAlphabet) {
+				t.Errorf("%s: expected ErrInvalidAlphabet, received %v", e.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if !regexp.MustCompile(e.pattern).MatchString(s) {
+			t.Errorf("%s: %q does not match %s", e.name, s, e.pattern)
+		}
+	}
+
+	if bits := (TokenGenerator{Alphabet: AlphabetHex, Length: 8}).Bits(); bits != 32 {
+		t.Errorf("expected 32 bits, received %v", bits)
+	}
+	if bits := (TokenGenerator{}).Bits(); bits < 128 {
+		t.Errorf("expected at least 128 bits by default, received %v", bits)
+	}
+}
+
+func TestTokenHelpers(t *testing.T) {
+	code, err := InviteCode()
+	if err != nil || !regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{4}-[0-9A-HJKMNP-TV-Z]{4}$`).MatchString(code) {
+		t.Errorf("unexpected invite code %q (%v)", code, err)
+	}
+	key, err := APIKey("sk_test_")
+	if err != nil || !regexp.MustCompile(`^sk_test_[1-9A-HJ-NP-Za-km-z]{32}$`).MatchString(key) {
+		t.Errorf("unexpected API key %q (%v)", key, err)
+	}
+	name, err := RandomFileName(".png")
+	if err != nil || !regexp.MustCompile(`^[0-9a-z]{25}\.png$`).MatchString(name) {
+		t.Errorf("unexpected file name %q (%v)", name, err)
+	}
+}
+
+func BenchmarkRandomString(b *testing.B) {
+	var testTools Tools
+	for _, n := range []int{10, 25, 100} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := testTools.RandomString(n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRandomStringParallel(b *testing.B) {
+	var testTools Tools
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := testTools.RandomString(25); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
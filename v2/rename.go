@@ -0,0 +1,119 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// slugSuffixLength is the number of random characters RenameSlug appends to the slug.
+const slugSuffixLength = 8
+
+// ErrInvalidFileName is returned by UploadFile when Rename returns a name that is empty, absolute, or not a
+// clean relative path.
+var ErrInvalidFileName = errors.New("the new file name is not valid")
+
+// RenameInfo describes an uploaded file to a RenameFunc.
+type RenameInfo struct {
+	OriginalFileName string
+	FieldName        string
+	ContentType      string
+	// Ext is the lower cased extension of the original file name, including the dot, or the extension of the
+	// format Images converted the file to.
+	Ext string
+	// SHA256 is the hex encoded SHA-256 of the content that will be stored. It is empty when Rename is not set
+	// and RenameRandom is used, as the file is named before its content is read.
+	SHA256   string
+	Uploader string
+	// Time is when the file was uploaded.
+	Time time.Time
+}
+
+// RenameFunc returns the name to store an uploaded file under, relative to the upload directory. The name may
+// contain "/" to store the file in a subdirectory, which is created if necessary.
+type RenameFunc func(info RenameInfo) (string, error)
+
+// RenameRandom names files with 25 random lowercase letters and digits followed by their extension.
+func RenameRandom(info RenameInfo) (string, error) {
+	return RenameID(IDRandom)(info)
+}
+
+// RenameUUID names files with a version 7 UUID followed by their extension, so that the names sort in the
+// order the files were uploaded.
+func RenameUUID(info RenameInfo) (string, error) {
+	return RenameID(IDUUIDv7)(info)
+}
+
+// RenameID returns a RenameFunc that names files with a new ID of the given format followed by their
+// extension.
+func RenameID(format IDFormat) RenameFunc {
+	return func(info RenameInfo) (string, error) {
+		id, err := newIDAt(format, info.Time)
+		if err != nil {
+			return "", err
+		}
+		return id + info.Ext, nil
+	}
+}
+
+// RenameContentHash names files with the SHA-256 of their content followed by their extension. Unlike
+// ContentAddressed, a file whose content is already stored is written again.
+func RenameContentHash(info RenameInfo) (string, error) {
+	return info.SHA256 + info.Ext, nil
+}
+
+// RenameSlug names files with their original name passed through SanitizeFileName, with a hyphen and 8
+// random characters added before the extension, such as "annual-report-k3f9x2qa.pdf".
+func RenameSlug(info RenameInfo) (string, error) {
+	name := sanitizeFileName(info.OriginalFileName)
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	suffix, err := TokenGenerator{Alphabet: AlphabetFileName, Length: slugSuffixLength}.Generate()
+	if err != nil {
+		return "", err
+	}
+	return truncateStem(stem, maxFileNameLength-len(info.Ext)-len(suffix)-1) + "-" + suffix + info.Ext, nil
+}
+
+// RenameDatePartitioned returns a RenameFunc that stores files in a directory for the UTC date they were
+// uploaded, such as "2026/10/16/", under the name given by next. RenameRandom is used if next is nil.
+func RenameDatePartitioned(next RenameFunc) RenameFunc {
+	if next == nil {
+		next = RenameRandom
+	}
+	return func(info RenameInfo) (string, error) {
+		name, err := next(info)
+		if err != nil {
+			return "", err
+		}
+		return info.Time.UTC().Format("2006/01/02/") + name, nil
+	}
+}
+
+// rename names f with Rename, or RenameRandom if Rename is not set, ext being the extension it will be stored
+// with.
+func (t *Tools) rename(f *UploadedFile, ext string) (string, error) {
+	rename := t.Rename
+	if rename == nil {
+		rename = RenameRandom
+	}
+	name, err := rename(RenameInfo{
+		OriginalFileName: f.OriginalFileName,
+		FieldName:        f.FieldName,
+		ContentType:      f.ContentType,
+		Ext:              ext,
+		SHA256:           f.SHA256,
+		Uploader:         f.Uploader,
+		Time:             t.now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) ||
+		path.Clean(name) != name || strings.ContainsRune(name, '\\') {
+		return "", fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	return name, nil
+}
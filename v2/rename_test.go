@@ -0,0 +1,100 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestToolsUploadFileRename(t *testing.T) {
+	data := []byte("quarterly numbers")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+	renameTests := []struct {
+		name    string
+		rename  RenameFunc
+		pattern string
+	}{
+		{name: "random", rename: RenameRandom, pattern: `^[0-9a-z]{25}\.txt$`},
+		{name: "uuid", rename: RenameUUID, pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.txt$`},
+		{name: "ulid", rename: RenameID(IDULID), pattern: `^[0-9A-HJKMNP-TV-Z]{26}\.txt$`},
+		{name: "content hash", rename: RenameContentHash, pattern: `^` + hash + `\.txt$`},
+		{name: "slug", rename: RenameSlug, pattern: `^q3-report-[0-9a-z]{8}\.txt$`},
+		{name: "date partitioned", rename: RenameDatePartitioned(RenameContentHash), pattern: `^2026/10/16/` + hash + `\.txt$`},
+		{name: "date partitioned random", rename: RenameDatePartitioned(nil), pattern: `^2026/10/16/[0-9a-z]{25}\.txt$`},
+	}
+
+	for _, e := range renameTests {
+		for _, stream := range []bool{false, true} {
+			uploadDir := t.TempDir()
+			testTools := Tools{Rename: e.rename, StreamUploads: stream, Now: func() time.Time { return now }}
+
+			r := newMultipartRequest(t, testPart{field: "file", fileName: "Q3 Report.TXT", data: data})
+			f, err := testTools.UploadOneFile(r, uploadDir, true)
+			if err != nil {
+				t.Errorf("%s, stream %v: unexpected error %v", e.name, stream, err)
+				continue
+			}
+			if !regexp.MustCompile(e.pattern).MatchString(f.NewFileName) {
+				t.Errorf("%s, stream %v: %s does not match %s", e.name, stream, f.NewFileName, e.pattern)
+			}
+			b, err := os.ReadFile(filepath.Join(uploadDir, filepath.FromSlash(f.NewFileName)))
+			if err != nil || string(b) != string(data) {
+				t.Errorf("%s, stream %v: expected the file to be stored (%v)", e.name, stream, err)
+			}
+		}
+	}
+}
+
+func TestToolsUploadFileRenameInfo(t *testing.T) {
+	var info RenameInfo
+	testTools := Tools{
+		Uploader: func(*http.Request) string { return "alice" },
+		Rename: func(i RenameInfo) (string, error) {
+			info = i
+			return "kept.txt", nil
+		},
+	}
+
+	r := newMultipartRequest(t, testPart{field: "attachment", fileName: "notes.TXT", data: []byte("some notes")})
+	f, err := testTools.UploadOneFile(r, t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NewFileName != "kept.txt" {
+		t.Errorf("expected the name returned by Rename, received %s", f.NewFileName)
+	}
+	if info.OriginalFileName != "notes.TXT" || info.FieldName != "attachment" || info.Ext != ".txt" ||
+		info.Uploader != "alice" || info.SHA256 != f.SHA256 || info.ContentType != f.ContentType || info.Time.IsZero() {
+		t.Errorf("unexpected RenameInfo %+v", info)
+	}
+
+	// the original name is kept when rename is false
+	f, err = testTools.UploadOneFile(newMultipartRequest(t, testPart{field: "file", fileName: "notes.txt", data: []byte("x")}), t.TempDir(), false)
+	if err != nil || f.NewFileName != "notes.txt" {
+		t.Errorf("expected the original name, received %v (%v)", f, err)
+	}
+}
+
+func TestToolsUploadFileRenameInvalid(t *testing.T) {
+	for _, name := range []string{"", ".", "../escape.txt", "/etc/passwd", "a//b.txt", "a/./b.txt", `a\b.txt`} {
+		testTools := Tools{Rename: func(RenameInfo) (string, error) { return name, nil }}
+		uploadDir := t.TempDir()
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "a.txt", data: []byte("x")})
+		if _, err := testTools.UploadOneFile(r, uploadDir, true); !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("%q: expected ErrInvalidFileName, received %v", name, err)
+		}
+		if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+			t.Errorf("%q: expected nothing to be stored, found %d files", name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package toolkit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scanner is implemented by virus and malware scanners. UploadFile calls Scan with the content of each file
+// once it has been received in full, before the file is stored.
+type Scanner interface {
+	Scan(r io.Reader) (ScanResult, error)
+}
+
+// ScanResult is the verdict of a Scanner.
+type ScanResult struct {
+	Infected bool
+	// Signature names what was found in an infected file.
+	Signature string
+}
+
+// MalwareError is returned by UploadFile when Scanner finds an uploaded file to be infected.
+type MalwareError struct {
+	FileName  string
+	Signature string
+	// QuarantinePath is where a copy of the file was kept, if QuarantineDir is set. The file itself is never
+	// stored in the upload directory.
+	QuarantinePath string
+}
+
+func (e *MalwareError) Error() string {
+	return fmt.Sprintf("the uploaded file %s is infected with %s", e.FileName, e.Signature)
+}
+
+// scan runs Scanner, if it is set, over the spooled file tmp and rewinds it afterwards. Infected files are
+// copied to QuarantineDir and reported with a *MalwareError. A scan that cannot be completed is an error, so
+// that nothing is stored unscanned.
+func (t *Tools) scan(tmp *os.File, f *UploadedFile) error {
+	if t.Scanner == nil {
+		return nil
+	}
+
+	result, err := t.Scanner.Scan(tmp)
+	if _, serr := tmp.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to scan %s: %w", f.OriginalFileName, err)
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	malwareErr := &MalwareError{FileName: f.OriginalFileName, Signature: result.Signature}
+	if t.QuarantineDir != "" {
+		p, err := t.quarantine(tmp, f.OriginalFileName)
+		if err != nil {
+			return fmt.Errorf("%w (quarantine failed: %v)", malwareErr, err)
+		}
+		malwareErr.QuarantinePath = p
+	}
+	return malwareErr
+}
+
+// quarantine copies tmp to QuarantineDir, readable only by its owner, and returns the path of the copy.
+func (t *Tools) quarantine(tmp *os.File, originalFileName string) (string, error) {
+	if err := os.MkdirAll(t.QuarantineDir, 0700); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s-%s", t.now().UTC().Format("20060102T150405Z"), hex.EncodeToString(b), t.SanitizeFileName(originalFileName))
+	p := filepath.Join(t.QuarantineDir, name)
+
+	out, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, tmp)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(p)
+		return "", err
+	}
+
+	return p, nil
+}
+
+// ClamdScanner is a Scanner that sends files to a ClamAV daemon using its INSTREAM command.
+type ClamdScanner struct {
+	// Network is "tcp" or "unix". The default is "tcp".
+	Network string
+	// Address is the address of clamd, such as "127.0.0.1:3310" or "/var/run/clamav/clamd.ctl".
+	Address string
+	// Timeout limits how long a whole scan may take. The default is one minute.
+	Timeout time.Duration
+	// ChunkSize is the size of the chunks the file is streamed in. The default is 64 kb. It must be smaller
+	// than the StreamMaxLength configured for clamd.
+	ChunkSize int
+}
+
+// Scan streams r to clamd and returns its verdict.
+func (c *ClamdScanner) Scan(r io.Reader) (ScanResult, error) {
+	network, timeout, chunkSize := c.Network, c.Timeout, c.ChunkSize
+	if network == "" {
+		network = "tcp"
+	}
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	if chunkSize <= 0 {
+		chunkSize = 64 * 1024
+	}
+
+	conn, err := net.DialTimeout(network, c.Address, timeout)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return ScanResult{}, err
+	}
+
+	if err := c.stream(conn, r, chunkSize); err != nil {
+		// clamd hangs up when the stream is too long, in which case its reply says more than the write error
+		if result, rerr := readClamdReply(conn); rerr == nil || !errors.Is(rerr, io.EOF) {
+			return result, rerr
+		}
+		return ScanResult{}, err
+	}
+
+	return readClamdReply(conn)
+}
+
+// stream sends the INSTREAM command followed by r as length prefixed chunks and a terminating empty chunk.
+func (c *ClamdScanner) stream(w io.Writer, r io.Reader, chunkSize int) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// readClamdReply reads and interprets a reply such as "stream: OK" or "stream: Eicar-Signature FOUND".
+func readClamdReply(r io.Reader) (ScanResult, error) {
+	reply, err := bufio.NewReader(r).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		return ScanResult{}, err
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	_, verdict, ok := strings.Cut(reply, ": ")
+	if !ok {
+		verdict = reply
+	}
+
+	switch {
+	case verdict == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,130 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd listens on a local port and answers INSTREAM commands like clamd, reporting any stream containing
+// the EICAR test string as infected. It returns the address to connect to.
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+
+				var data bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+						return
+					}
+				}
+
+				if bytes.Contains(data.Bytes(), []byte(eicar)) {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				io.WriteString(conn, "stream: OK\x00")
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner := &ClamdScanner{Address: fakeClamd(t), ChunkSize: 7}
+
+	result, err := scanner.Scan(strings.NewReader(strings.Repeat("clean content ", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Error("expected clean content to pass")
+	}
+
+	result, err = scanner.Scan(strings.NewReader("prefix " + eicar + " suffix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("expected the EICAR signature to be found, received %+v", result)
+	}
+
+	if _, err := readClamdReply(strings.NewReader("INSTREAM size limit exceeded. ERROR\x00")); err == nil {
+		t.Error("expected an error reply to be reported")
+	}
+
+	unreachable := &ClamdScanner{Address: "127.0.0.1:1"}
+	if _, err := unreachable.Scan(strings.NewReader("x")); err == nil {
+		t.Error("expected an error when clamd is unreachable")
+	}
+}
+
+func TestToolsUploadFileScanner(t *testing.T) {
+	uploadDir, quarantineDir := t.TempDir(), t.TempDir()
+	testTools := Tools{Scanner: &ClamdScanner{Address: fakeClamd(t)}, QuarantineDir: quarantineDir}
+
+	for _, stream := range []bool{false, true} {
+		testTools.StreamUploads = stream
+
+		r := newMultipartRequest(t, testPart{field: "file", fileName: "clean.txt", data: []byte("nothing to see here")})
+		if _, err := testTools.UploadOneFile(r, uploadDir); err != nil {
+			t.Errorf("stream %v: unexpected error %v", stream, err)
+		}
+
+		r = newMultipartRequest(t, testPart{field: "file", fileName: "virus.txt", data: []byte(eicar)})
+		_, err := testTools.UploadOneFile(r, uploadDir)
+		var malwareErr *MalwareError
+		if !errors.As(err, &malwareErr) {
+			t.Fatalf("stream %v: expected a *MalwareError, received %v", stream, err)
+		}
+		if malwareErr.FileName != "virus.txt" || malwareErr.Signature != "Eicar-Test-Signature" {
+			t.Errorf("stream %v: unexpected error %+v", stream, malwareErr)
+		}
+		b, err := os.ReadFile(malwareErr.QuarantinePath)
+		if err != nil || string(b) != eicar {
+			t.Errorf("stream %v: expected the file to be quarantined (%v)", stream, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(uploadDir)
+	if len(entries) != 2 {
+		t.Errorf("expected only the clean files to be stored, found %d files", len(entries))
+	}
+}
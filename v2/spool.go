@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveSpooled is used by saveFile when a file has to be read in full before it can be stored: to scan it
+// with Scanner, to process it with Images, or to name it after its content when ContentAddressed or Rename is
+// set. in is copied to a temporary file which is committed to uploadDir once every check has passed. With
+// ContentAddressed, a file whose content is already stored in uploadDir is not written again and is returned
+// with Duplicate set. If exclusive is set the file keeps its name and is committed with commitKept.
+func (t *Tools) saveSpooled(in io.Reader, f *UploadedFile, uploadDir string, sums *checksums, exclusive bool) (*UploadedFile, error) {
+	tmp, size, err := t.spool(uploadDir, in)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { discardSpool(tmp) }()
+
+	sums.apply(f)
+	f.FileSize = size
+
+	if err := t.scan(tmp, f); err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(f.OriginalFileName))
+	var thumbs []*encodedImage
+	if t.Images.handles(f.ContentType) {
+		var newExt string
+		tmp, newExt, thumbs, err = t.processImage(tmp, f, uploadDir)
+		if err != nil {
+			return nil, err
+		}
+		if newExt != "" {
+			ext = newExt
+			if f.NewFileName != "" {
+				f.NewFileName = strings.TrimSuffix(f.NewFileName, filepath.Ext(f.NewFileName)) + ext
+			}
+		}
+	}
+
+	switch {
+	case t.ContentAddressed:
+		f.NewFileName = f.SHA256 + ext
+		exists, err := t.fileExists(uploadDir, f.NewFileName)
+		if err != nil {
+			return nil, err
+		}
+		f.Duplicate = exists
+	case f.NewFileName == "":
+		if f.NewFileName, err = t.rename(f, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case f.Duplicate:
+	case exclusive:
+		if err := t.commitKept(uploadDir, f, tmp); err != nil {
+			return nil, err
+		}
+	default:
+		if err := t.commit(storageKey(uploadDir, f.NewFileName), tmp, false); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.saveVariants(uploadDir, f, thumbs, exclusive); err != nil {
+		// f has not been added to the Quota yet, so it is only deleted
+		if !f.Duplicate {
+			t.deleteUploaded(uploadDir, f)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// spool copies in to a temporary file and returns it, positioned at the start, along with its size. When
+// files are written to the local file system the temporary file is created in uploadDir so that commit can
+// simply rename it.
+func (t *Tools) spool(uploadDir string, in io.Reader) (*os.File, int64, error) {
+	dir := uploadDir
+	if t.Storage != nil {
+		dir = ""
+	}
+
+	tmp, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(tmp, in)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		discardSpool(tmp)
+		return nil, 0, err
+	}
+
+	return tmp, size, nil
+}
+
+// commit stores a file returned by spool under key. If exclusive is set it fails with an error wrapping
+// fs.ErrExist when key is taken, and can then be called again with another key.
+func (t *Tools) commit(key string, tmp *os.File, exclusive bool) error {
+	if t.Storage == nil {
+		if err := tmp.Chmod(0644); err != nil {
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.FromSlash(key)), 0755); err != nil {
+			return err
+		}
+		if exclusive {
+			// the link leaves tmp open and in place for another try, to be removed by discardSpool
+			return linkFile(tmp.Name(), filepath.FromSlash(key))
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return renameFile(tmp.Name(), filepath.FromSlash(key))
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var opts []PutOption
+	if exclusive {
+		opts = append(opts, PutExclusive)
+	}
+	_, err := t.Storage.Put(key, tmp, opts...)
+	return err
+}
+
+// discardSpool closes and removes a file returned by spool. It does nothing to a file that has been renamed by commit.
+func discardSpool(tmp *os.File) {
+	tmp.Close()
+	os.Remove(tmp.Name())
+}
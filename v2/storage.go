@@ -0,0 +1,401 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is implemented by the backends that uploaded files are written to and served from. Names are
+// slash separated keys such as "uploads/avatar.png". Implementations return an error wrapping fs.ErrNotExist
+// from Open, Stat and Delete when the name does not exist.
+type Storage interface {
+	// Put stores everything read from r under name, replacing any existing object unless PutExclusive is
+	// given, and returns the number of bytes written. If reading from r fails nothing is stored under name.
+	Put(name string, r io.Reader, opts ...PutOption) (int64, error)
+	// Open returns a reader for the object stored under name.
+	Open(name string) (io.ReadCloser, error)
+	// Stat returns information about the object stored under name.
+	Stat(name string) (ObjectInfo, error)
+	// Delete removes the object stored under name.
+	Delete(name string) error
+	// List returns every object whose name starts with prefix, sorted by name.
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// ObjectInfo describes an object held in a Storage.
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// PutOption changes how Storage.Put stores an object.
+type PutOption int
+
+const (
+	// PutExclusive makes Put fail with an error wrapping fs.ErrExist if an object is already stored under the
+	// name, instead of replacing it. Checking for the object and storing the new one is a single atomic step,
+	// so of two Puts of the same name at the same time only one succeeds.
+	PutExclusive PutOption = iota + 1
+)
+
+// isExclusive reports whether opts include PutExclusive.
+func isExclusive(opts []PutOption) bool {
+	for _, o := range opts {
+		if o == PutExclusive {
+			return true
+		}
+	}
+	return false
+}
+
+// tempFilePrefix starts the names of the temporary files that uploads are written to before they are given
+// their final name.
+const tempFilePrefix = ".upload-"
+
+// isTempFile reports whether name is a temporary file of an upload in progress.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(path.Base(name), tempFilePrefix)
+}
+
+// storage returns the Storage that uploads are written to and downloads served from. If none has been set
+// the local file system is used.
+func (t *Tools) storage() Storage {
+	if t.Storage != nil {
+		return t.Storage
+	}
+	return &LocalStorage{}
+}
+
+// prepareUploadDir makes sure uploadDir exists when files are written to the local file system.
+func (t *Tools) prepareUploadDir(uploadDir string) error {
+	if t.Storage != nil {
+		return nil
+	}
+	return t.CreateDirIfNotExists(uploadDir)
+}
+
+// storageKey returns the Storage name of the file fileName in uploadDir.
+func storageKey(uploadDir, fileName string) string {
+	return path.Join(filepath.ToSlash(uploadDir), fileName)
+}
+
+// serveFromStorage writes the object name held in Storage to w. Range and conditional requests are supported
+// when the Storage returns readers that can seek.
+func (t *Tools) serveFromStorage(w http.ResponseWriter, r *http.Request, name string) {
+	info, err := t.Storage.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := t.Storage.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(name), info.ModTime, rs)
+		return
+	}
+
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" && w.Header().Get("content-type") == "" {
+		w.Header().Set("content-type", ct)
+	}
+	w.Header().Set("content-length", strconv.FormatInt(info.Size, 10))
+	if !info.ModTime.IsZero() {
+		w.Header().Set("last-modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
+}
+
+// LocalStorage is a Storage that keeps objects as files below Dir. If Dir is empty names are used as file
+// paths as they are, relative to the working directory.
+type LocalStorage struct {
+	Dir string
+}
+
+// path returns the file path of name, refusing names that would escape Dir.
+func (s *LocalStorage) path(name string) (string, error) {
+	if s.Dir == "" {
+		return filepath.FromSlash(name), nil
+	}
+
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid storage name %q", name)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+// Put writes r to the file for name, creating any missing parent directories. The data is written to a
+// temporary file in the same directory which is synced and then renamed, so readers never see a partly
+// written file and a failed Put leaves nothing behind. With PutExclusive the temporary file is hard linked to
+// name instead, which fails if name exists.
+func (s *LocalStorage) Put(name string, r io.Reader, opts ...PutOption) (int64, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), tempFilePrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	// temporary files are only readable by their owner, so give the file the permissions os.Create would
+	err = f.Chmod(0644)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(f, r)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+	case isExclusive(opts):
+		err = linkFile(f.Name(), p)
+		os.Remove(f.Name())
+	default:
+		err = renameFile(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Open opens the file for name. The returned reader is an *os.File and so also implements io.Seeker.
+func (s *LocalStorage) Open(name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Stat returns the size and modification time of the file for name.
+func (s *LocalStorage) Stat(name string) (ObjectInfo, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s is a directory: %w", name, fs.ErrNotExist)
+	}
+
+	return ObjectInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Delete removes the file for name.
+func (s *LocalStorage) Delete(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// List walks the directory holding prefix and returns the files whose names start with prefix. Directories that
+// cannot hold such files are not entered, and the temporary files of Puts in progress are left out.
+func (s *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "./")
+	base := filepath.Dir(filepath.FromSlash(prefix))
+	if s.Dir != "" {
+		base = filepath.Join(s.Dir, base)
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		name := filepath.ToSlash(p)
+		if s.Dir != "" {
+			rel, err := filepath.Rel(s.Dir, p)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(rel)
+		}
+		if d.IsDir() {
+			if p != base && !strings.HasPrefix(name+"/", prefix) && !strings.HasPrefix(prefix, name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(name, prefix) || isTempFile(name) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// MemoryStorage is a Storage that keeps objects in memory. It is mainly intended for tests. The zero value is
+// ready to use and it is safe for concurrent use.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// memoryReader allows the bytes of a MemoryStorage object to be read, seeked and closed.
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error { return nil }
+
+// Put reads r fully and stores a copy under name.
+func (s *MemoryStorage) Put(name string, r io.Reader, opts ...PutOption) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string]memoryObject)
+	}
+	if _, ok := s.objects[name]; ok && isExclusive(opts) {
+		return 0, fmt.Errorf("put %s: %w", name, fs.ErrExist)
+	}
+	s.objects[name] = memoryObject{data: data, modTime: time.Now()}
+
+	return int64(len(data)), nil
+}
+
+// Open returns a reader over the stored bytes. The reader also implements io.Seeker.
+func (s *MemoryStorage) Open(name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", name, fs.ErrNotExist)
+	}
+	return memoryReader{bytes.NewReader(o.data)}, nil
+}
+
+// Stat returns the size and time of storage of the object.
+func (s *MemoryStorage) Stat(name string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[name]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", name, fs.ErrNotExist)
+	}
+	return ObjectInfo{Name: name, Size: int64(len(o.data)), ModTime: o.modTime}, nil
+}
+
+// Delete removes the object.
+func (s *MemoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[name]; !ok {
+		return fmt.Errorf("delete %s: %w", name, fs.ErrNotExist)
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+// List returns the objects whose names start with prefix.
+func (s *MemoryStorage) List(prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var objects []ObjectInfo
+	for name, o := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, ObjectInfo{Name: name, Size: int64(len(o.data)), ModTime: o.modTime})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// renameFile moves the file at oldPath to newPath, replacing anything already there, and then syncs the
+// directory so that the rename survives a crash. Not every platform can sync a directory, so failures to
+// do so are ignored.
+func renameFile(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if d, err := os.Open(filepath.Dir(newPath)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// linkFile makes newPath a hard link to oldPath, failing with an error wrapping fs.ErrExist if newPath exists,
+// and then syncs the directory as renameFile does.
+func linkFile(oldPath, newPath string) error {
+	if err := os.Link(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if d, err := os.Open(filepath.Dir(newPath)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the hex encoded SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Storage is a Storage that keeps objects in a bucket of an S3 compatible object store such as AWS S3,
+// MinIO or Ceph. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	// Endpoint is the base URL of the service, for example "https://s3.eu-west-1.amazonaws.com" or
+	// "http://localhost:9000".
+	Endpoint string
+	// Region is the signing region. It defaults to "us-east-1".
+	Region string
+	Bucket string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only needed for temporary credentials.
+	SessionToken string
+
+	// VirtualHostedStyle addresses the bucket as a sub domain of Endpoint rather than as the first path element.
+	VirtualHostedStyle bool
+
+	// Client is used to send requests. If nil the standard http.Client is used.
+	Client *http.Client
+
+	// now is used to time stamp requests. It is only replaced by tests.
+	now func() time.Time
+}
+
+// S3Error is returned when the object store replies with an error status.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Is reports a missing object as fs.ErrNotExist, and an object that exists when PutExclusive was given as
+// fs.ErrExist.
+func (e *S3Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case fs.ErrExist:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// Put uploads r as the object name. S3 needs to know the length and hash of the body up front, so unless r
+// is an io.ReadSeeker it is first copied to a temporary file. PutExclusive sends If-None-Match: *, which the
+// store answers with 412 Precondition Failed if the object exists.
+func (s *S3Storage) Put(name string, r io.Reader, opts ...PutOption) (int64, error) {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "toolkit-s3-*")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err := io.Copy(tmp, r); err != nil {
+			return 0, err
+		}
+		body = tmp
+	}
+
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	req, err := s.newRequest(http.MethodPut, name, nil, io.NopCloser(body))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if isExclusive(opts) {
+		req.Header.Set("if-none-match", "*")
+	}
+	res, err := s.do(req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return size, nil
+}
+
+// Open downloads the object name. The caller must close the returned reader.
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Stat issues a HEAD request for the object name.
+func (s *S3Storage) Stat(name string) (ObjectInfo, error) {
+	req, err := s.newRequest(http.MethodHead, name, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	res.Body.Close()
+
+	info := ObjectInfo{Name: name, Size: res.ContentLength}
+	if lm := res.Header.Get("last-modified"); lm != "" {
+		info.ModTime, _ = http.ParseTime(lm)
+	}
+	return info, nil
+}
+
+// Delete removes the object name. S3 does not report deletes of missing objects, so Delete checks that the
+// object exists first in order to honour the Storage contract.
+func (s *S3Storage) Delete(name string) error {
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// List pages through ListObjectsV2 and returns every object whose name starts with prefix.
+func (s *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var result struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	var objects []ObjectInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		result.Contents, result.IsTruncated, result.NextContinuationToken = nil, false, ""
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{Name: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// newRequest builds an unsigned request for the object name, or for the bucket itself if name is empty.
+func (s *S3Storage) newRequest(method, name string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	if s.Bucket == "" {
+		return nil, errors.New("s3: no bucket specified")
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.VirtualHostedStyle {
+		u.Host = s.Bucket + "." + u.Host
+	} else {
+		p += "/" + s.Bucket
+	}
+	if name != "" {
+		p += "/" + strings.TrimPrefix(name, "/")
+	} else if !s.VirtualHostedStyle {
+		p += "/"
+	}
+	if p == "" {
+		p = "/"
+	}
+	u.Path, u.RawPath = p, s3EscapePath(p)
+	u.RawQuery = s3EscapeQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = body
+	}
+	return req, nil
+}
+
+// do signs and sends req. Responses with a status outside 2xx are turned into an *S3Error.
+func (s *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, payloadHash, now())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	s3Err := &S3Error{}
+	if req.Method != http.MethodHead {
+		xml.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(s3Err)
+	}
+	s3Err.StatusCode = res.StatusCode
+	return nil, s3Err
+}
+
+// sign adds AWS Signature Version 4 headers to req. The host header, the x-amz-* headers and any range,
+// content-type or content-md5 headers are signed.
+func (s *S3Storage) sign(req *http.Request, payloadHash string, t time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" || k == "content-md5" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3EscapeQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	crh := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crh[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent encodes s as required by Signature Version 4: everything other than the unreserved
+// characters is encoded, and "/" is left alone when encoding paths.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// s3EscapeQuery encodes query with its keys sorted, as needed for the canonical request.
+func s3EscapeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
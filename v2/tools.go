@@ -2,18 +2,18 @@ package toolkit
 
 import (
 	"bytes"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+"
@@ -25,30 +25,150 @@ type Tools struct {
 	AllowedTypes       []string
 	MaxJSONSize        int
 	AllowUnknownFields bool
-}
 
-// RandomString returns a string of randomn characters of length n, using randomStringSource
-// as the source for teh characters of the string
-func (t *Tools) RandomString(n int) string {
-	s, r := make([]rune, n), []rune(randomStringSource)
+	// StreamUploads makes UploadFile read the request with r.MultipartReader() instead of
+	// r.ParseMultipartForm(), writing each file straight to uploadDir as it arrives. In this mode
+	// MaxFileSize is enforced as a per-file limit while copying.
+	StreamUploads bool
+
+	// Storage is where UploadFile writes files and DownLoadStaticFile serves them from. If nil the local file
+	// system is used. Files are stored under the name uploadDir/fileName.
+	Storage Storage
+
+	// ChecksumMD5 and ChecksumCRC32C add MD5 and CRC-32C checksums to uploaded files, in addition to the
+	// SHA-256 that is always computed.
+	ChecksumMD5    bool
+	ChecksumCRC32C bool
+
+	// ContentAddressed names uploaded files after the SHA-256 of their content, whatever the value of rename.
+	// A file whose content has already been uploaded to the same directory is not written again.
+	ContentAddressed bool
+
+	// Rename names the files UploadFile renames. The default is RenameRandom. Other strategies include
+	// RenameID(IDUUIDv7), RenameSlug and RenameDatePartitioned(RenameUUID). As the name may depend on the
+	// content, files are spooled to a temporary file before they are stored when Rename is set. ContentAddressed
+	// takes precedence.
+	Rename RenameFunc
+
+	// AllOrNothing makes UploadFile remove every file it has written for a request if any file in the request
+	// fails, instead of keeping and returning the files that were uploaded before the failure.
+	AllOrNothing bool
+
+	// KeepPartial makes UploadFile try every file of a request even when some of them fail, keeping the files
+	// that succeed, instead of stopping at the first failure. The failures are listed in a *MultiUploadError.
+	// AllOrNothing takes precedence.
+	KeepPartial bool
+
+	// OnCollision decides what happens when a file uploaded without renaming has the same name as an existing
+	// file. The default is to overwrite it.
+	OnCollision CollisionPolicy
+
+	// CheckExtension rejects uploads whose extension does not agree with the content type detected from their
+	// content, according to mime.TypeByExtension. If AllowedExtensions is not empty only the extensions it
+	// lists, such as ".png", are accepted. Rejections are reported with an *ExtensionMismatchError.
+	CheckExtension    bool
+	AllowedExtensions []string
+
+	// Detectors, if set, is used instead of http.DetectContentType to work out the type of uploaded files
+	// that is checked against AllowedTypes.
+	Detectors *DetectorRegistry
+
+	// Scanner, if set, scans every uploaded file once it has been received and before it is stored. Infected
+	// files are rejected with a *MalwareError and, if QuarantineDir is set, a copy is kept there.
+	Scanner       Scanner
+	QuarantineDir string
+
+	// Images, if set, processes uploaded JPEG, PNG and GIF images before they are stored, for example to strip
+	// their metadata or to make thumbnails.
+	Images *ImagePipeline
+
+	// ArchiveLimits limits the archives unpacked by ExtractArchive and ExtractUploadedFile.
+	ArchiveLimits ArchiveLimits
+
+	// OnProgress, if set, is called by UploadFile each time more of a file has been stored and once the file is
+	// complete. It is called on the goroutine storing the file, which is the one handling the request unless
+	// Concurrency is above one, so it should return quickly and, with Concurrency, be safe to call from several
+	// goroutines at once. Uploads stop with the error of the request context when it is cancelled, for
+	// example because the client went away, and the file being stored is discarded.
+	OnProgress func(UploadProgress)
+
+	// MaxRequestSize limits the size in bytes of the whole request body read by UploadFile. Zero means there
+	// is no limit other than MaxFileSize.
+	MaxRequestSize int64
+
+	// FieldRules, if not empty, lists the form fields that may carry files, and the limits for each of them.
+	// Files in any other field are rejected with ErrFieldNotAllowed.
+	FieldRules map[string]FieldRule
+
+	// Uploader, if set, returns who is uploading in r, such as the user name of an authenticated request. It
+	// is recorded on each UploadedFile.
+	Uploader func(r *http.Request) string
+
+	// Metadata, if set, stores the FileMetadata of every uploaded file. DownLoadStaticFile uses it to restore
+	// the original file name and content type of files that were renamed.
+	Metadata MetadataStore
+
+	// TTL, if set, makes uploaded files expire TTL after they are uploaded. The expiry is recorded in
+	// Metadata, which must be set, and expired files are removed by a Janitor. UploadFile fails with
+	// ErrTTLWithoutMetadata if it is not.
+	TTL time.Duration
+
+	// Quota, if set, limits how many bytes each Uploader may store. A file that would take its uploader over
+	// quota is rejected with a *QuotaError as soon as it does.
+	Quota Quota
+
+	// Concurrency, if above one, makes UploadFile store that many files of a request at once, so that the
+	// work done once a file has been received, such as scanning it or resizing images, is not done one file
+	// at a time. Files are still returned in the same order as without it: sorted by form field name and then
+	// in the order they were sent, or simply in the order they were sent with StreamUploads. Rather than
+	// stopping at the first file that fails, every file is tried as with KeepPartial. With StreamUploads, files
+	// are spooled to temporary files while they wait to be stored.
+	Concurrency int
+
+	// Now returns the current time. If nil time.Now is used. It can be replaced to control time in tests.
+	Now func() time.Time
+}
 
-	for i := range s {
-		p, err := rand.Prime(rand.Reader, len(r))
-		if err != nil {
-			log.Fatal("Unexpected error ", err)
-		}
-		x, y := p.Uint64(), uint64(len(r))
-		s[i] = r[x%y]
+// RandomString returns a string of n random characters from randomStringSource. Every character is equally
+// likely, using randomness from crypto/rand. An error is returned if crypto/rand fails. As randomStringSource
+// includes '+', use a TokenGenerator for strings that go in URLs or file names, and a PasswordPolicy for
+// passwords that must contain particular kinds of characters.
+func (t *Tools) RandomString(n int) (string, error) {
+	if n <= 0 {
+		return "", nil
 	}
-
-	return string(s)
+	return TokenGenerator{Alphabet: randomStringSource, Length: n}.Generate()
 }
 
 // UploadedFile is a struct to store information about an file that has been uploaded.
 type UploadedFile struct {
-	NewFileName      string
-	OriginalFileName string
-	FileSize         int64
+	NewFileName      string `json:"new_file_name"`
+	OriginalFileName string `json:"original_file_name"`
+	FileSize         int64  `json:"file_size"`
+
+	// FieldName is the name of the form field the file was uploaded in.
+	FieldName string `json:"field_name,omitempty"`
+
+	// ContentType is the type detected from the content of the file.
+	ContentType string `json:"content_type"`
+
+	// SHA256, MD5 and CRC32C are hex encoded checksums of the content. MD5 and CRC32C are only set when
+	// enabled in Tools.
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"`
+	CRC32C string `json:"crc32c,omitempty"`
+
+	// Duplicate is true when ContentAddressed is set and the content was already stored under NewFileName.
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// Variants are the images derived from the file by the Images pipeline, such as thumbnails.
+	Variants []ImageVariant `json:"variants,omitempty"`
+
+	// Uploader is who uploaded the file, as returned by the Uploader of Tools.
+	Uploader   string    `json:"uploader,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	// ExpiresAt is when the file expires if TTL is set in Tools. It is the zero time otherwise.
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // UploadOneFile is a convenience function that is used to upload just one single file. This simply calls the more
@@ -67,95 +187,81 @@ func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool)
 	return uploadedFile[0], nil
 }
 
-// UploadFile reads and loads files to a specified directory. If rename is true it uses the RandomString()
-// function to generate a new file name. The extension of the file is always the same as that of the original file name.
+// UploadFile reads and loads files to a specified directory. If rename is true it uses Rename to generate a new
+// file name. The extension of the file is always that of the original file name, lower cased.
+// If rename is false the original file name is passed through SanitizeFileName and OnCollision is applied.
+// UploadFile is UploadForm without the form values.
 func (t *Tools) UploadFile(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	result, err := t.UploadForm(r, uploadDir, rename...)
+	return result.Files, err
+}
+
+// UploadForm uploads files like UploadFile and also returns the values of the form fields that are not files.
+// The result is never nil. If an error is returned it holds the files that were kept.
+func (t *Tools) UploadForm(r *http.Request, uploadDir string, rename ...bool) (*UploadResult, error) {
 	renameFile := true
 	if len(rename) > 0 {
 		renameFile = rename[0]
 	}
 
-	var upLoadedFiles []*UploadedFile
+	result := &UploadResult{Values: url.Values{}}
 
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024 // 1 gb approximately
+	if err := t.checkTTL(); err != nil {
+		return result, err
+	}
+	if err := t.limitRequestBody(r); err != nil {
+		return result, err
 	}
-	if err := r.ParseMultipartForm(int64(t.MaxFileSize)); err != nil {
-		log.Println(r)
-		log.Fatal("Fatal:", err)
-		return nil, errors.New(err.Error())
+	if t.StreamUploads {
+		return t.streamUploadForm(r, uploadDir, renameFile, result)
+	}
+	if err := r.ParseMultipartForm(t.maxFileSize()); err != nil {
+		return result, badMultipart(err)
 	}
 
-	if err := t.CreateDirIfNotExists(uploadDir); err != nil {
-		return nil, err
+	if err := t.prepareUploadDir(uploadDir); err != nil {
+		return result, err
+	}
+
+	for field, values := range r.MultipartForm.Value {
+		result.Values[field] = values
 	}
 
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdrs := range fHeaders {
-			var err error
-			upLoadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				inFile, err := hdrs.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer inFile.Close()
-
-				buf := make([]byte, 512)
-				_, err = inFile.Read(buf)
-				if err != nil {
-					return nil, err
-				}
-
-				// Check to see of the file type is permitted.
-				allowed := false
-				fileType := http.DetectContentType(buf)
-
-				if len(t.AllowedTypes) > 0 {
-					for _, x := range t.AllowedTypes {
-						if strings.EqualFold(x, fileType) {
-							allowed = true
-							break
-						}
-					}
-				} else {
-					allowed = true
-				}
-				if !allowed {
-					return nil, errors.New("the uploaded file type is not permitted")
-				}
-
-				_, err = inFile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
-				var uploadedFile UploadedFile
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdrs.Filename))
-				} else {
-					uploadedFile.NewFileName = hdrs.Filename
-				}
-				uploadedFile.OriginalFileName = hdrs.Filename
-
-				if outFile, err := os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outFile, inFile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-				return uploadedFiles, nil
-
-			}(upLoadedFiles)
+	// files are stored in a fixed order, by field name and then in the order they were sent
+	fields := make([]string, 0, len(r.MultipartForm.File))
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	state := t.newUploadState(r)
+	pool := t.newUploadPool(uploadDir, renameFile, state)
+	for _, field := range fields {
+		for _, hdrs := range r.MultipartForm.File[field] {
+			hdrs := hdrs
+			state.counts[field]++
+			p, err := t.partFor(field, hdrs.Filename, 0, state.counts[field])
+			if cerr := state.ctx.Err(); cerr != nil {
+				return t.abortUpload(result, pool, cerr)
+			}
+
+			err = pool.submit(uploadJob{p: p, err: err, open: func() (io.ReadCloser, error) { return hdrs.Open() }})
 			if err != nil {
-				return upLoadedFiles, err
+				return t.abortUpload(result, pool, err)
 			}
 		}
 	}
 
-	return upLoadedFiles, nil
+	return t.finishUpload(result, pool)
+}
+
+// maxFileSize returns MaxFileSize, or 1 gb if it is not set. t is not changed, as it may be shared by
+// concurrent requests.
+func (t *Tools) maxFileSize() int64 {
+	if t.MaxFileSize == 0 {
+		return 1024 * 1024 * 1024 // 1 gb approximately
+	}
+	return int64(t.MaxFileSize)
 }
 
 // CreateDirIfNotExists creates a directory along with all needed parent directories if they dont exist.
@@ -195,10 +301,51 @@ func (t *Tools) Slugify(s string) (string, error) {
 	return slug, nil
 }
 
+// contentDisposition returns an attachment content-disposition for the file name displayName, which may come from
+// the client that uploaded the file. The name is always quoted, with quotes and backslashes escaped. Names that
+// are not printable ASCII are also sent as filename* in UTF-8, with an ASCII fallback in filename.
+func contentDisposition(displayName string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, displayName)
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fallback)
+	if fallback == displayName {
+		return fmt.Sprintf("attachment; filename=\"%s\"", quoted)
+	}
+
+	var encoded strings.Builder
+	for _, b := range []byte(displayName) {
+		// the attr-char of RFC 8187, everything else is percent encoded
+		if b < 0x80 && (b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", quoted, encoded.String())
+}
+
 // DowloadStaticFile downloads a file and tries to force the browser not to display it by setting the
-// content-disposition. It also allows specification of the display name.
+// content-disposition. It also allows specification of the display name. If Storage is set, pathname is the
+// name of the file in the Storage. If Metadata holds metadata for pathname, its content type is sent and an
+// empty displayName is replaced by the original file name.
 func (t *Tools) DownLoadStaticFile(w http.ResponseWriter, r *http.Request, pathname, displayName string) {
-	w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
+	if m, ok := t.loadMetadata(pathname); ok {
+		if displayName == "" {
+			displayName = m.OriginalFileName
+		}
+		if m.ContentType != "" {
+			w.Header().Set("content-type", m.ContentType)
+		}
+	}
+	w.Header().Set("content-disposition", contentDisposition(displayName))
+	if t.Storage != nil {
+		t.serveFromStorage(w, r, pathname)
+		return
+	}
 	http.ServeFile(w, r, pathname)
 }
 
@@ -292,7 +439,8 @@ func (t * Tools) WriteJSON(w http.ResponseWriter, status int, data interface{},
 }
 
 // ErroJSON takes an error and an optional status code and writes the error in JSON format as the response.
-// The default status if none specified is http.StatusBadRequest
+// If no status is specified and err has a StatusCode() int method, such as *QuotaError, its status is used,
+// otherwise the default status is http.StatusBadRequest
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
 	// create a JSONResponse
 	jr := JSONResponse{
@@ -301,8 +449,11 @@ func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error
 
 	}
 	statusCode := http.StatusBadRequest
+	var sc interface{ StatusCode() int }
 	if len(status) > 0 {
 		statusCode = status[0]
+	} else if errors.As(err, &sc) {
+		statusCode = sc.StatusCode()
 	}
 
 	return t.WriteJSON(w, statusCode, jr)
@@ -23,7 +23,10 @@ func TestToolsRandomString(t *testing.T) {
 	var testTools Tools
 
 	n := 10
-	s := testTools.RandomString(n)
+	s, err := testTools.RandomString(n)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(s) != n {
 		t.Errorf("Unexpected length. Expected: %d, Got: %d", n, len(s))
 	}
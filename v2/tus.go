@@ -0,0 +1,518 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusOctets     = "application/offset+octet-stream"
+)
+
+// TusHandler is an http.Handler that implements version 1.0 of the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload) along with its creation, termination and expiration extensions.
+// Incomplete uploads are kept in Dir. Once all the bytes of an upload have arrived the file is checked
+// against the AllowedTypes and MaxFileSize of Tools and saved to UploadDir exactly as UploadFile would. A
+// record of a completed upload is kept until it expires, so that HEAD still reports its final offset and
+// Upload returns the saved file.
+type TusHandler struct {
+	Tools *Tools
+	// Dir holds incomplete uploads. It is created if it does not exist.
+	Dir string
+	// UploadDir is the directory, or Storage prefix, that completed uploads are saved to.
+	UploadDir string
+	// BasePath is the URL path the handler is mounted at, for example "/files/". It is used to build the
+	// location of new uploads.
+	BasePath string
+	// KeepFileName saves completed uploads under the file name sent by the client instead of a random name.
+	KeepFileName bool
+	// Expiration is how long an incomplete upload is kept after it was last written to. The default is 24 hours.
+	// Expiry times are worked out from the Now of Tools.
+	Expiration time.Duration
+	// OnComplete, if set, is called with each upload once it has been saved. The saved file can also be looked
+	// up later with Upload.
+	OnComplete func(r *http.Request, f *UploadedFile)
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// ErrUploadIncomplete is returned by TusHandler.Upload when not all the bytes of an upload have arrived.
+var ErrUploadIncomplete = errors.New("the upload has not been completed")
+
+// tusInfo is stored as JSON next to the data of an upload. Once the upload has been saved the data is
+// removed and File is set.
+type tusInfo struct {
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Expires  time.Time         `json:"expires"`
+	File     *UploadedFile     `json:"file,omitempty"`
+}
+
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("tus-resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("tus-version", tusVersion)
+		w.Header().Set("tus-extension", tusExtensions)
+		w.Header().Set("tus-max-size", strconv.FormatInt(h.maxSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("tus-resumable") != tusVersion {
+		w.Header().Set("tus-version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	if err := os.MkdirAll(h.Dir, 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		h.create(w, r)
+	case id == "" || strings.ContainsAny(id, `/\.`):
+		http.NotFound(w, r)
+	case r.Method == http.MethodHead:
+		h.head(w, r, id)
+	case r.Method == http.MethodPatch:
+		h.patch(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		w.Header().Set("allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Upload returns the file saved for the completed upload id. It returns ErrUploadIncomplete if the upload is
+// still in progress and an error wrapping fs.ErrNotExist if there is no such upload or it has expired.
+func (h *TusHandler) Upload(id string) (*UploadedFile, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("upload %s: %w", id, fs.ErrNotExist)
+	}
+	info, err := h.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if !h.tools().now().Before(info.Expires) {
+		return nil, fmt.Errorf("upload %s has expired: %w", id, fs.ErrNotExist)
+	}
+	if info.File == nil {
+		return nil, ErrUploadIncomplete
+	}
+	return info.File, nil
+}
+
+// RemoveExpired deletes the uploads, and the records of completed uploads, whose expiry time has passed.
+func (h *TusHandler) RemoveExpired() error {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".info")
+		if !ok || !h.lock(id) {
+			continue
+		}
+		info, err := h.readInfo(id)
+		if err == nil && !h.tools().now().Before(info.Expires) {
+			h.remove(id)
+		}
+		h.unlock(id)
+	}
+
+	return nil
+}
+
+// create handles POST requests, which start a new upload.
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	if err := h.tools().checkTTL(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("upload-length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "a valid upload-length header is required", http.StatusBadRequest)
+		return
+	}
+	if length > h.maxSize() {
+		http.Error(w, ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("upload-metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b)
+
+	info := tusInfo{Length: length, Metadata: metadata, Expires: h.tools().now().Add(h.expiration())}
+	if err := os.WriteFile(h.dataPath(id), nil, 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.writeInfo(id, info); err != nil {
+		h.remove(id)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if length == 0 {
+		h.lock(id)
+		defer h.unlock(id)
+		if status, err := h.complete(r, id, info); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("location", strings.TrimSuffix(h.BasePath, "/")+"/"+id)
+	w.Header().Set("upload-expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head handles HEAD requests, which tell the client how much of an upload has been received.
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	info, offset, ok := h.load(w, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("cache-control", "no-store")
+	w.Header().Set("upload-offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("upload-length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("upload-expires", info.Expires.UTC().Format(http.TimeFormat))
+	if len(info.Metadata) > 0 {
+		w.Header().Set("upload-metadata", formatTusMetadata(info.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch handles PATCH requests, which append bytes to an upload.
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("content-type") != tusOctets {
+		http.Error(w, "content-type must be "+tusOctets, http.StatusUnsupportedMediaType)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("upload-offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		http.Error(w, "a valid upload-offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.lock(id) {
+		http.Error(w, "the upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	info, offset, ok := h.load(w, id)
+	if !ok {
+		return
+	}
+	if info.File != nil && clientOffset == offset && r.ContentLength <= 0 {
+		// the reply to the last PATCH may have been lost, so tell the client again that the upload is done
+		w.Header().Set("upload-offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("upload-expires", info.Expires.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if clientOffset != offset || info.File != nil {
+		http.Error(w, "upload-offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(h.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, &maxBytesReader{r: r.Body, n: info.Length - offset})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	offset += n
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			// throw away everything past the declared length and let the client retry from the right offset
+			os.Truncate(h.dataPath(id), info.Length)
+			http.Error(w, "the request body extends past upload-length", http.StatusBadRequest)
+			return
+		}
+		// the bytes that made it to disk are kept so that the client can resume from the new offset
+		w.Header().Set("upload-offset", strconv.FormatInt(offset, 10))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// reject files of the wrong type as soon as enough bytes have arrived to tell
+	if headLen := int64(h.tools().headLen()); offset-n < headLen && (offset >= headLen || offset == info.Length) {
+		if err := h.checkType(id); err != nil {
+			h.remove(id)
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	info.Expires = h.tools().now().Add(h.expiration())
+	if offset == info.Length {
+		if status, err := h.complete(r, id, info); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	} else if err := h.writeInfo(id, info); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("upload-offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("upload-expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate handles DELETE requests, which abandon an upload. For a completed upload only the record is
+// removed and the saved file is left alone.
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(w, "the upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	if _, _, ok := h.load(w, id); !ok {
+		return
+	}
+	h.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete saves a fully received upload to UploadDir and replaces its data in Dir with a record of the
+// saved file. It returns the status code to reply with if saving fails, in which case the upload is removed.
+func (h *TusHandler) complete(r *http.Request, id string, info tusInfo) (int, error) {
+	uploadedFile, status, err := h.save(r, id, info)
+	if err != nil {
+		h.remove(id)
+		return status, err
+	}
+
+	os.Remove(h.dataPath(id))
+	info.File = uploadedFile
+	if err := h.writeInfo(id, info); err != nil {
+		// the file has been saved, so only HEAD and Upload are affected
+		h.remove(id)
+	}
+
+	if h.OnComplete != nil {
+		h.OnComplete(r, uploadedFile)
+	}
+	return http.StatusNoContent, nil
+}
+
+// save stores the data of a fully received upload with storeFile.
+func (h *TusHandler) save(r *http.Request, id string, info tusInfo) (*UploadedFile, int, error) {
+	f, err := os.Open(h.dataPath(id))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	t := h.tools()
+	if err := t.prepareUploadDir(h.UploadDir); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	fileName := info.Metadata["filename"]
+	if fileName == "" {
+		fileName = id
+	}
+	p := uploadPart{fileName: filepath.Base(fileName), limit: h.maxSize(), allowedTypes: t.AllowedTypes}
+	var uploader string
+	if t.Uploader != nil {
+		uploader = t.Uploader(r)
+	}
+	uploadedFile, err := t.storeFile(f, p, h.UploadDir, !h.KeepFileName, uploader)
+	switch {
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return nil, http.StatusUnsupportedMediaType, err
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrQuotaExceeded):
+		return nil, http.StatusRequestEntityTooLarge, err
+	case err != nil:
+		return nil, http.StatusInternalServerError, err
+	}
+	return uploadedFile, http.StatusOK, nil
+}
+
+// checkType sniffs the start of an upload and checks it against AllowedTypes.
+func (h *TusHandler) checkType(id string) error {
+	f, err := os.Open(h.dataPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t := h.tools()
+	head := make([]byte, t.headLen())
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if !isAllowedType(t.AllowedTypes, t.detectContentType(head[:n])) {
+		return ErrFileTypeNotAllowed
+	}
+	return nil
+}
+
+// load reads the state of an upload. If the upload does not exist or has expired a reply is written to w
+// and ok is false.
+func (h *TusHandler) load(w http.ResponseWriter, id string) (info tusInfo, offset int64, ok bool) {
+	info, err := h.readInfo(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return info, 0, false
+	}
+	if !h.tools().now().Before(info.Expires) {
+		h.remove(id)
+		w.WriteHeader(http.StatusGone)
+		return info, 0, false
+	}
+	if info.File != nil {
+		return info, info.Length, true
+	}
+
+	fi, err := os.Stat(h.dataPath(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return info, 0, false
+	}
+	return info, fi.Size(), true
+}
+
+func (h *TusHandler) readInfo(id string) (tusInfo, error) {
+	var info tusInfo
+	b, err := os.ReadFile(h.infoPath(id))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+func (h *TusHandler) writeInfo(id string, info tusInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.infoPath(id), b, 0644)
+}
+
+func (h *TusHandler) remove(id string) {
+	os.Remove(h.dataPath(id))
+	os.Remove(h.infoPath(id))
+}
+
+func (h *TusHandler) dataPath(id string) string { return filepath.Join(h.Dir, id) }
+
+func (h *TusHandler) infoPath(id string) string { return filepath.Join(h.Dir, id+".info") }
+
+// lock marks an upload as in use. It returns false if another request is already using it.
+func (h *TusHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.locked == nil {
+		h.locked = make(map[string]bool)
+	}
+	if h.locked[id] {
+		return false
+	}
+	h.locked[id] = true
+	return true
+}
+
+func (h *TusHandler) unlock(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.locked, id)
+}
+
+func (h *TusHandler) tools() *Tools {
+	if h.Tools == nil {
+		return &Tools{}
+	}
+	return h.Tools
+}
+
+// maxSize is the largest upload accepted, which is MaxFileSize or 1 gb if that is not set.
+func (h *TusHandler) maxSize() int64 {
+	if t := h.tools(); t.MaxFileSize > 0 {
+		return int64(t.MaxFileSize)
+	}
+	return 1024 * 1024 * 1024
+}
+
+func (h *TusHandler) expiration() time.Duration {
+	if h.Expiration > 0 {
+		return h.Expiration
+	}
+	return 24 * time.Hour
+}
+
+// parseTusMetadata decodes an upload-metadata header, which is a comma separated list of keys each followed
+// by an optional base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.New("upload-metadata contains a badly encoded value for " + key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func formatTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return strings.Join(pairs, ",")
+}
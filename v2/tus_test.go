@@ -0,0 +1,183 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// tusRequest sends a tus request with the given method, path, headers and body to h.
+func tusRequest(h http.Handler, method, path string, body []byte, headers ...string) *http.Response {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("tus-resumable", tusVersion)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr.Result()
+}
+
+func TestTusHandler(t *testing.T) {
+	img := testPNG(t, 64, 64)
+	uploadDir := t.TempDir()
+	var completed *UploadedFile
+	h := &TusHandler{
+		Tools:        &Tools{AllowedTypes: []string{"image/png"}},
+		Dir:          t.TempDir(),
+		UploadDir:    uploadDir,
+		BasePath:     "/files/",
+		KeepFileName: true,
+		OnComplete:   func(r *http.Request, f *UploadedFile) { completed = f },
+	}
+
+	res := tusRequest(h, http.MethodOptions, "/files/", nil)
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("tus-extension") != tusExtensions {
+		t.Errorf("unexpected OPTIONS reply %d %v", res.StatusCode, res.Header)
+	}
+
+	res = tusRequest(h, http.MethodPost, "/files/", nil,
+		"upload-length", strconv.Itoa(len(img)),
+		"upload-metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("picture.png")))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d creating an upload, received %d", http.StatusCreated, res.StatusCode)
+	}
+	location := res.Header.Get("location")
+	if res.Header.Get("upload-expires") == "" {
+		t.Error("expected an upload-expires header")
+	}
+
+	res = tusRequest(h, http.MethodPatch, location, img[:600], "content-type", tusOctets, "upload-offset", "0")
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("upload-offset") != "600" {
+		t.Fatalf("unexpected PATCH reply %d with offset %s", res.StatusCode, res.Header.Get("upload-offset"))
+	}
+
+	res = tusRequest(h, http.MethodHead, location, nil)
+	if res.Header.Get("upload-offset") != "600" || res.Header.Get("upload-length") != strconv.Itoa(len(img)) {
+		t.Errorf("unexpected HEAD reply %v", res.Header)
+	}
+
+	res = tusRequest(h, http.MethodPatch, location, img[10:], "content-type", tusOctets, "upload-offset", "10")
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d for a wrong offset, received %d", http.StatusConflict, res.StatusCode)
+	}
+
+	res = tusRequest(h, http.MethodPatch, location, img[600:], "content-type", tusOctets, "upload-offset", "600")
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d completing the upload, received %d", http.StatusNoContent, res.StatusCode)
+	}
+	if completed == nil || completed.NewFileName != "picture.png" || completed.FileSize != int64(len(img)) {
+		t.Fatalf("unexpected completed upload %+v", completed)
+	}
+	b, err := os.ReadFile(filepath.Join(uploadDir, "picture.png"))
+	if err != nil || !bytes.Equal(b, img) {
+		t.Errorf("completed upload does not match the original (%v)", err)
+	}
+	res = tusRequest(h, http.MethodHead, location, nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("upload-offset") != strconv.Itoa(len(img)) {
+		t.Errorf("expected HEAD to report a completed upload as finished, received %d with offset %s", res.StatusCode, res.Header.Get("upload-offset"))
+	}
+	if f, err := h.Upload(path.Base(location)); err != nil || f.NewFileName != "picture.png" {
+		t.Errorf("expected Upload to return the saved file, received %+v (%v)", f, err)
+	}
+	res = tusRequest(h, http.MethodPatch, location, nil, "content-type", tusOctets, "upload-offset", strconv.Itoa(len(img)))
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("upload-offset") != strconv.Itoa(len(img)) {
+		t.Errorf("expected a repeated final PATCH to succeed, received %d", res.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(h.Dir, path.Base(location))); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the data of a completed upload to be removed, received %v", err)
+	}
+
+	res = tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", "10")
+	location = res.Header.Get("location")
+	if _, err := h.Upload(path.Base(location)); err != ErrUploadIncomplete {
+		t.Errorf("expected ErrUploadIncomplete, received %v", err)
+	}
+	if res := tusRequest(h, http.MethodDelete, location, nil); res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d terminating an upload, received %d", http.StatusNoContent, res.StatusCode)
+	}
+	if res := tusRequest(h, http.MethodHead, location, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a terminated upload to be gone, received %d", res.StatusCode)
+	}
+
+	res = tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", "1000")
+	location = res.Header.Get("location")
+	res = tusRequest(h, http.MethodPatch, location, bytes.Repeat([]byte("text "), 200), "content-type", tusOctets, "upload-offset", "0")
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d for a disallowed type, received %d", http.StatusUnsupportedMediaType, res.StatusCode)
+	}
+
+	if res := tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", strconv.Itoa(2<<30)); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d for an oversized upload, received %d", http.StatusRequestEntityTooLarge, res.StatusCode)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	r.Header.Set("upload-length", "10")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d without tus-resumable, received %d", http.StatusPreconditionFailed, rr.Code)
+	}
+}
+
+func TestTusHandlerExpiration(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	h := &TusHandler{
+		Tools:      &Tools{Now: func() time.Time { return now }},
+		Dir:        t.TempDir(),
+		UploadDir:  t.TempDir(),
+		BasePath:   "/files/",
+		Expiration: time.Hour,
+	}
+
+	res := tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", "10")
+	location := res.Header.Get("location")
+	expired := tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", "10").Header.Get("location")
+
+	now = now.Add(30 * time.Minute)
+	if res := tusRequest(h, http.MethodPatch, location, []byte("12345"), "content-type", tusOctets, "upload-offset", "0"); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, received %d", http.StatusNoContent, res.StatusCode)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if err := h.RemoveExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if res := tusRequest(h, http.MethodHead, expired, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an expired upload to be removed, received %d", res.StatusCode)
+	}
+	if res := tusRequest(h, http.MethodHead, location, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("expected an upload written to recently to be kept, received %d", res.StatusCode)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if res := tusRequest(h, http.MethodHead, location, nil); res.StatusCode != http.StatusGone {
+		t.Errorf("expected status %d for an expired upload, received %d", http.StatusGone, res.StatusCode)
+	}
+
+	// the record of a completed upload expires like an incomplete upload
+	res = tusRequest(h, http.MethodPost, "/files/", nil, "upload-length", "0")
+	completed := path.Base(res.Header.Get("location"))
+	if _, err := h.Upload(completed); err != nil {
+		t.Fatalf("expected a completed upload, received %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := h.Upload(completed); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected an expired record to be reported as missing, received %v", err)
+	}
+	if err := h.RemoveExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(h.Dir, completed+".info")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected an expired record to be removed, received %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is the number of bytes read from the start of a file to detect its content type.
+const sniffLen = 512
+
+// ErrFileTypeNotAllowed is returned when the detected type of an uploaded file is not in AllowedTypes.
+var ErrFileTypeNotAllowed = errors.New("the uploaded file type is not permitted")
+
+// ErrFileTooLarge is returned when an uploaded file is larger than the permitted size.
+var ErrFileTooLarge = errors.New("the uploaded file is too large")
+
+// streamUploadForm is the StreamUploads variant of UploadForm. Parts are read one at a time from
+// r.MultipartReader() and copied straight to the Storage, so nothing is buffered by the multipart parser.
+// Files and form values are added to result.
+func (t *Tools) streamUploadForm(r *http.Request, uploadDir string, renameFile bool, result *UploadResult) (*UploadResult, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return result, badMultipart(err)
+	}
+
+	if err := t.prepareUploadDir(uploadDir); err != nil {
+		return result, err
+	}
+
+	state := t.newUploadState(r)
+	pool := t.newUploadPool(uploadDir, renameFile, state)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return t.abortUpload(result, pool, badMultipart(err))
+		}
+		if err := state.ctx.Err(); err != nil {
+			return t.abortUpload(result, pool, err)
+		}
+
+		if err := t.streamPart(part, result.Values, state, pool); err != nil {
+			return t.abortUpload(result, pool, err)
+		}
+	}
+
+	return t.finishUpload(result, pool)
+}
+
+// streamPart submits a file part to pool to be stored. Parts that are not files are added to values instead.
+func (t *Tools) streamPart(part *multipart.Part, values url.Values, state *uploadState, pool *uploadPool) error {
+	defer part.Close()
+
+	if part.FileName() == "" {
+		return readFormValue(part, values, &state.valueBytes)
+	}
+
+	state.counts[part.FormName()]++
+	p, err := t.partFor(part.FormName(), part.FileName(), t.maxFileSize(), state.counts[part.FormName()])
+	job := uploadJob{p: p, err: err}
+	switch {
+	case err != nil:
+	case pool.parallel():
+		// the part cannot be read once the next one has been, so it is spooled for the goroutine that stores it
+		// the limits are applied while spooling, so that a file over them is not written to disk in full
+		var in io.Reader = &contextReader{ctx: state.ctx, r: part}
+		if p.limit > 0 {
+			in = &maxBytesReader{r: in, n: p.limit}
+		}
+		in, err := t.quotaReader(in, state.uploader)
+		if err != nil {
+			job.err = err
+			break
+		}
+		tmp, _, err := t.spool(pool.uploadDir, in)
+		if err != nil {
+			job.err = err
+			break
+		}
+		job.open = func() (io.ReadCloser, error) { return spooledFile{tmp}, nil }
+	default:
+		job.open = func() (io.ReadCloser, error) { return io.NopCloser(part), nil }
+	}
+
+	return pool.submit(job)
+}
+
+// storeFile saves src with saveFile, within the Quota of owner, and records the upload with recordUpload.
+func (t *Tools) storeFile(src io.Reader, p uploadPart, uploadDir string, renameFile bool, owner string) (*UploadedFile, error) {
+	src, err := t.quotaReader(src, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	p.uploader = owner
+	uploadedFile, err := t.saveFile(src, p, uploadDir, renameFile)
+	if err != nil {
+		return nil, err
+	}
+	if t.Quota != nil && !uploadedFile.Duplicate {
+		if err := t.Quota.Add(owner, uploadedFile.FileSize); err != nil {
+			t.deleteUploaded(uploadDir, uploadedFile)
+			return nil, err
+		}
+	}
+	if err := t.recordUpload(uploadDir, uploadedFile, owner); err != nil {
+		return nil, err
+	}
+
+	return uploadedFile, nil
+}
+
+// uploadPart describes a file for saveFile to store.
+type uploadPart struct {
+	field    string
+	fileName string
+	// limit is the largest size accepted. Zero means there is no limit.
+	limit        int64
+	allowedTypes []string
+	// uploader is who is uploading the file, as returned by Uploader.
+	uploader string
+}
+
+// saveFile detects the content type from the first bytes of src, checks it against the allowed types of p and
+// copies src to uploadDir in the Storage. Files larger than the limit of p are not stored and ErrFileTooLarge
+// is returned.
+func (t *Tools) saveFile(src io.Reader, p uploadPart, uploadDir string, renameFile bool) (*UploadedFile, error) {
+	head := make([]byte, t.headLen())
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	fileType := t.detectContentType(head)
+	if !isAllowedType(p.allowedTypes, fileType) {
+		return nil, ErrFileTypeNotAllowed
+	}
+	if err := t.checkExtension(p.fileName, fileType); err != nil {
+		return nil, err
+	}
+
+	uploadedFile := UploadedFile{OriginalFileName: p.fileName, FieldName: p.field, ContentType: fileType, Uploader: p.uploader}
+	switch {
+	case t.ContentAddressed, renameFile && t.Rename != nil:
+		// named by saveSpooled once the content has been read
+	case renameFile:
+		uploadedFile.NewFileName, err = t.rename(&uploadedFile, strings.ToLower(filepath.Ext(p.fileName)))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		uploadedFile.NewFileName, err = t.keptFileName(uploadDir, p.fileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	in := io.MultiReader(bytes.NewReader(head), src)
+	if p.limit > 0 {
+		in = &maxBytesReader{r: in, n: p.limit}
+	}
+	sums := t.newChecksums()
+	in = io.TeeReader(in, sums)
+
+	// a kept name is claimed with PutExclusive, so that another upload cannot take it between the check made
+	// by keptFileName and the write
+	exclusive := !t.ContentAddressed && !renameFile && t.OnCollision != CollisionOverwrite
+	if uploadedFile.NewFileName == "" || exclusive || t.Scanner != nil || t.Images.handles(fileType) {
+		return t.saveSpooled(in, &uploadedFile, uploadDir, sums, exclusive)
+	}
+
+	fileSize, err := t.storage().Put(storageKey(uploadDir, uploadedFile.NewFileName), in)
+	if err != nil {
+		return nil, err
+	}
+	uploadedFile.FileSize = fileSize
+	sums.apply(&uploadedFile)
+
+	return &uploadedFile, nil
+}
+
+// rollback is called when a request fails part way through. If AllOrNothing is set the files already written
+// for the request are removed and nil is returned, otherwise files is returned unchanged. Duplicates of content
+// stored by earlier requests are left alone.
+func (t *Tools) rollback(uploadDir string, files []*UploadedFile) []*UploadedFile {
+	if !t.AllOrNothing {
+		return files
+	}
+
+	for _, f := range files {
+		t.removeUploaded(uploadDir, f)
+	}
+	return nil
+}
+
+// removeUploaded deletes f, its metadata and its Variants from uploadDir and frees its space in Quota, unless f is a
+// Duplicate of content stored earlier.
+func (t *Tools) removeUploaded(uploadDir string, f *UploadedFile) {
+	if f.Duplicate {
+		return
+	}
+	t.deleteUploaded(uploadDir, f)
+	if t.Quota != nil {
+		t.Quota.Add(f.Uploader, -f.FileSize)
+	}
+}
+
+// deleteUploaded deletes f, its metadata and its Variants from uploadDir.
+func (t *Tools) deleteUploaded(uploadDir string, f *UploadedFile) {
+	t.storage().Delete(storageKey(uploadDir, f.NewFileName))
+	if t.Metadata != nil {
+		t.Metadata.Delete(storageKey(uploadDir, f.NewFileName))
+	}
+	for _, v := range f.Variants {
+		t.storage().Delete(storageKey(uploadDir, v.FileName))
+	}
+}
+
+// isAllowedType reports whether fileType is one of allowed. An empty allowed permits everything.
+func isAllowedType(allowed []string, fileType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, x := range allowed {
+		if strings.EqualFold(x, fileType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxBytesReader reads from r until more than n bytes have been read, after which it fails with err, or
+// ErrFileTooLarge if err is nil.
+type maxBytesReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, m.tooLarge()
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	if m.n < 0 {
+		return n, m.tooLarge()
+	}
+	return n, err
+}
+
+func (m *maxBytesReader) tooLarge() error {
+	if m.err != nil {
+		return m.err
+	}
+	return ErrFileTooLarge
+}
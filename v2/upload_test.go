@@ -0,0 +1,155 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPart describes one part of a multipart request built by newMultipartRequest. Parts with an empty
+// fileName are written as plain form fields.
+type testPart struct {
+	field    string
+	fileName string
+	data     []byte
+}
+
+// newMultipartRequest builds a POST request whose body is a multipart form containing parts.
+func newMultipartRequest(t *testing.T, parts ...testPart) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, p := range parts {
+		if p.fileName == "" {
+			if err := writer.WriteField(p.field, string(p.data)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		part, err := writer.CreateFormFile(p.field, p.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("content-type", writer.FormDataContentType())
+	return r
+}
+
+// testPNG returns a w x h PNG image filled with noise, so that it does not compress well.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(int64(w*h + 1)))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var streamUploadTests = []struct {
+	name         string
+	allowedTypes []string
+	maxFileSize  int
+	renameFile   bool
+	errExpected  error
+}{
+	{name: "allowed no rename", allowedTypes: []string{"image/png"}, renameFile: false},
+	{name: "allowed rename", allowedTypes: []string{"image/png", "image/jpeg"}, renameFile: true},
+	{name: "file type not allowed", allowedTypes: []string{"image/jpeg"}, renameFile: true, errExpected: ErrFileTypeNotAllowed},
+	{name: "file too large", allowedTypes: []string{"image/png"}, maxFileSize: 100, renameFile: true, errExpected: ErrFileTooLarge},
+}
+
+func TestToolsUploadFileStreaming(t *testing.T) {
+	img := testPNG(t, 64, 64)
+	for _, e := range streamUploadTests {
+		dir := t.TempDir()
+		r := newMultipartRequest(t,
+			testPart{field: "caption", data: []byte("a caption")},
+			testPart{field: "file", fileName: "image.png", data: img},
+		)
+		testTools := Tools{AllowedTypes: e.allowedTypes, MaxFileSize: e.maxFileSize, StreamUploads: true}
+
+		files, err := testTools.UploadFile(r, dir, e.renameFile)
+		if e.errExpected != nil {
+			if !errors.Is(err, e.errExpected) {
+				t.Errorf("%s: expected error %v, received %v", e.name, e.errExpected, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s: expected no files to be left behind, found %d", e.name, len(entries))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+
+		if len(files) != 1 {
+			t.Errorf("%s: expected 1 uploaded file, received %d", e.name, len(files))
+			continue
+		}
+		if !e.renameFile && files[0].NewFileName != "image.png" {
+			t.Errorf("%s: expected file name image.png, received %s", e.name, files[0].NewFileName)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, files[0].NewFileName))
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if !bytes.Equal(b, img) || files[0].FileSize != int64(len(img)) {
+			t.Errorf("%s: uploaded file does not match the original", e.name)
+		}
+	}
+}
+
+func TestToolsUploadFileAllOrNothing(t *testing.T) {
+	img := testPNG(t, 16, 16)
+	for _, stream := range []bool{false, true} {
+		for _, allOrNothing := range []bool{false, true} {
+			dir := t.TempDir()
+			r := newMultipartRequest(t,
+				testPart{field: "file", fileName: "first.png", data: img},
+				testPart{field: "file", fileName: "second.txt", data: []byte("plain text is not allowed")},
+			)
+			testTools := Tools{AllowedTypes: []string{"image/png"}, StreamUploads: stream, AllOrNothing: allOrNothing}
+
+			files, err := testTools.UploadFile(r, dir)
+			if !errors.Is(err, ErrFileTypeNotAllowed) {
+				t.Errorf("stream %v: expected ErrFileTypeNotAllowed, received %v", stream, err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if allOrNothing && (len(files) != 0 || len(entries) != 0) {
+				t.Errorf("stream %v: expected every file to be removed, %d returned and %d on disk", stream, len(files), len(entries))
+			}
+			if !allOrNothing && (len(files) != 1 || len(entries) != 1) {
+				t.Errorf("stream %v: expected the first file to be kept, %d returned and %d on disk", stream, len(files), len(entries))
+			}
+		}
+	}
+}
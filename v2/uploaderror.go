@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FileError is the failure of one file of a request.
+type FileError struct {
+	FieldName        string
+	OriginalFileName string
+	Err              error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.OriginalFileName, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Reason is a short code for why the file failed, for clients to act on: "too_large", "type_not_allowed",
+// "infected", "exists", "rejected" for files refused by FieldRules and other client errors, or "io" for
+// failures on the server.
+func (e *FileError) Reason() string {
+	switch UploadErrorStatus(e.Err) {
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusUnsupportedMediaType:
+		return "type_not_allowed"
+	case http.StatusUnprocessableEntity:
+		return "infected"
+	case http.StatusConflict:
+		return "exists"
+	case http.StatusInternalServerError:
+		return "io"
+	default:
+		return "rejected"
+	}
+}
+
+// MarshalJSON writes the error as {"field_name", "original_file_name", "reason", "message"}.
+func (e *FileError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FieldName        string `json:"field_name,omitempty"`
+		OriginalFileName string `json:"original_file_name"`
+		Reason           string `json:"reason"`
+		Message          string `json:"message"`
+	}{e.FieldName, e.OriginalFileName, e.Reason(), e.Err.Error()})
+}
+
+// MultiUploadError is returned by UploadFile when files of a request fail and Concurrency is above one or
+// KeepPartial is set. It lists each failed file, in the order the files were sent, and matches the error of
+// any of them with errors.Is and errors.As. Otherwise UploadFile returns the error of the first file that
+// fails as it is.
+type MultiUploadError struct {
+	Files []*FileError
+}
+
+func (e *MultiUploadError) Error() string {
+	msgs := make([]string, len(e.Files))
+	for i, f := range e.Files {
+		msgs[i] = f.Error()
+	}
+	if len(e.Files) == 1 {
+		return msgs[0]
+	}
+	return fmt.Sprintf("%d files failed: %s", len(e.Files), strings.Join(msgs, "; "))
+}
+
+func (e *MultiUploadError) Unwrap() []error {
+	errs := make([]error, len(e.Files))
+	for i, f := range e.Files {
+		errs[i] = f
+	}
+	return errs
+}
+
+// StatusCode is the status from UploadErrorStatus shared by every failed file, or 400 Bad Request if they
+// differ.
+func (e *MultiUploadError) StatusCode() int {
+	status := http.StatusBadRequest
+	for i, f := range e.Files {
+		s := UploadErrorStatus(f.Err)
+		if i > 0 && s != status {
+			return http.StatusBadRequest
+		}
+		status = s
+	}
+	return status
+}
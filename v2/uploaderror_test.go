@@ -0,0 +1,88 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToolsUploadFileKeepPartial(t *testing.T) {
+	parts := []testPart{
+		{field: "photos", fileName: "a.png", data: testPNG(t, 2, 2)},
+		{field: "photos", fileName: "b.txt", data: []byte("not an image")},
+		{field: "photos", fileName: "c.png", data: testPNG(t, 2, 2)},
+		{field: "photos", fileName: "d.png", data: testPNG(t, 64, 64)},
+	}
+
+	for _, stream := range []bool{false, true} {
+		testTools := Tools{StreamUploads: stream, AllowedTypes: []string{"image/png"}, FieldRules: map[string]FieldRule{"photos": {MaxFileSize: 4096}}}
+
+		// without KeepPartial the error of the first file to fail is returned as it is
+		files, err := testTools.UploadFile(newMultipartRequest(t, parts...), t.TempDir())
+		if err != ErrFileTypeNotAllowed {
+			t.Fatalf("stream %v: expected the upload to stop at b.txt, received %v", stream, err)
+		}
+		if len(files) != 1 {
+			t.Errorf("stream %v: expected only a.png to be kept, received %d files", stream, len(files))
+		}
+
+		testTools.KeepPartial = true
+		files, err = testTools.UploadFile(newMultipartRequest(t, parts...), t.TempDir())
+		var multiErr *MultiUploadError
+		if !errors.As(err, &multiErr) || len(multiErr.Files) != 2 {
+			t.Fatalf("stream %v: expected two failures, received %v", stream, err)
+		}
+		for i, e := range []struct{ name, field, reason string }{{"b.txt", "photos", "type_not_allowed"}, {"d.png", "photos", "too_large"}} {
+			f := multiErr.Files[i]
+			if f.OriginalFileName != e.name || f.FieldName != e.field || f.Reason() != e.reason {
+				t.Errorf("stream %v: expected %s to fail with %s, received %s with %s", stream, e.name, e.reason, f.OriginalFileName, f.Reason())
+			}
+		}
+		if len(files) != 2 || files[0].OriginalFileName != "a.png" || files[1].OriginalFileName != "c.png" {
+			t.Errorf("stream %v: expected a.png and c.png to be kept, received %d files", stream, len(files))
+		}
+		if !errors.Is(err, ErrFileTooLarge) || multiErr.StatusCode() != http.StatusBadRequest {
+			t.Errorf("stream %v: unexpected error matching or status %d", stream, multiErr.StatusCode())
+		}
+	}
+
+	b, err := json.Marshal(&FileError{FieldName: "photos", OriginalFileName: "d.png", Err: ErrFileTooLarge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"field_name":"photos","original_file_name":"d.png","reason":"too_large","message":"the uploaded file is too large"}` {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestUploadHandlerKeepPartial(t *testing.T) {
+	h := &UploadHandler{Tools: &Tools{KeepPartial: true, AllowedTypes: []string{"image/png"}}, UploadDir: t.TempDir()}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newMultipartRequest(t,
+		testPart{field: "file", fileName: "a.png", data: testPNG(t, 2, 2)},
+		testPart{field: "file", fileName: "b.txt", data: []byte("text")},
+	))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status %d, received %d", http.StatusMultiStatus, rr.Code)
+	}
+
+	var res struct {
+		Error bool `json:"error"`
+		Data  struct {
+			Files  []*UploadedFile `json:"files"`
+			Errors []struct {
+				OriginalFileName string `json:"original_file_name"`
+				Reason           string `json:"reason"`
+			} `json:"errors"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Error || len(res.Data.Files) != 1 || len(res.Data.Errors) != 1 || res.Data.Errors[0].Reason != "type_not_allowed" {
+		t.Errorf("unexpected response %+v", res)
+	}
+}